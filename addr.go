@@ -0,0 +1,137 @@
+package lobby
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// AddrFamily is an IP address family.
+type AddrFamily int
+
+const (
+	// AddrAny does not prefer any address family.
+	AddrAny = AddrFamily(iota)
+	// AddrIPv4 prefers IPv4 addresses.
+	AddrIPv4
+	// AddrIPv6 prefers IPv6 addresses.
+	AddrIPv6
+)
+
+func (f AddrFamily) String() string {
+	switch f {
+	case AddrAny:
+		return "any"
+	case AddrIPv4:
+		return "ipv4"
+	case AddrIPv6:
+		return "ipv6"
+	}
+	return fmt.Sprintf("AddrFamily(%d)", int(f))
+}
+
+// ParseAddrFamily parses address family name: "any", "ipv4" ("4") or "ipv6" ("6").
+func ParseAddrFamily(s string) (AddrFamily, error) {
+	switch s {
+	case "", "any":
+		return AddrAny, nil
+	case "ipv4", "4":
+		return AddrIPv4, nil
+	case "ipv6", "6":
+		return AddrIPv6, nil
+	}
+	return 0, fmt.Errorf("unknown address family: %q", s)
+}
+
+// addrFamily returns a family of the address.
+func addrFamily(a netip.Addr) AddrFamily {
+	switch {
+	case a.Is4():
+		return AddrIPv4
+	case a.Is6():
+		return AddrIPv6
+	}
+	return AddrAny
+}
+
+// parseAddr parses an IP address and converts it to the canonical form:
+// IPv4-mapped IPv6 addresses are converted to IPv4 and IPv6 zones are removed.
+func parseAddr(s string) (netip.Addr, error) {
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return a.Unmap().WithZone(""), nil
+}
+
+// CanonicalAddr returns a canonical text form of the IP address, so that the same address is always formatted the same way.
+func CanonicalAddr(s string) (string, error) {
+	a, err := parseAddr(s)
+	if err != nil {
+		return "", err
+	}
+	return a.String(), nil
+}
+
+// parsePrefix parses a network in CIDR notation. A single IP address is accepted as well.
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip, err := parseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return p.Masked(), nil
+}
+
+// canonicalAddrs validates and normalizes game addresses in place.
+func (g *Game) canonicalAddrs() error {
+	addr, err := parseAddr(g.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+	g.Address = addr.String()
+	if g.AltAddress == "" {
+		return nil
+	}
+	alt, err := parseAddr(g.AltAddress)
+	if err != nil {
+		return fmt.Errorf("invalid alternative address: %w", err)
+	}
+	if addrFamily(alt) == addrFamily(addr) {
+		return errors.New("alternative address must be of a different address family")
+	}
+	g.AltAddress = alt.String()
+	return nil
+}
+
+// AddrFor returns the game address of a given family, or an empty string if the game has no such address.
+// For AddrAny, the main game address is returned.
+func (g *Game) AddrFor(f AddrFamily) string {
+	if f == AddrAny {
+		return g.Address
+	}
+	for _, s := range []string{g.Address, g.AltAddress} {
+		if a, err := parseAddr(s); err == nil && addrFamily(a) == f {
+			return a.String()
+		}
+	}
+	return ""
+}
+
+// PreferAddr swaps game addresses, so that the main address is of a preferred family, if the game has one.
+func (g *Game) PreferAddr(f AddrFamily) {
+	if f == AddrAny || g.AltAddress == "" {
+		return
+	}
+	if g.AddrFor(f) == g.AltAddress {
+		g.Address, g.AltAddress = g.AltAddress, g.Address
+	}
+}
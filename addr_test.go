@@ -0,0 +1,115 @@
+package lobby
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalAddr(t *testing.T) {
+	for _, c := range []struct {
+		in, exp string
+	}{
+		{"1.2.3.4", "1.2.3.4"},
+		{"::ffff:1.2.3.4", "1.2.3.4"},
+		{"2001:DB8:0:0::1", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+	} {
+		got, err := CanonicalAddr(c.in)
+		require.NoError(t, err, c.in)
+		require.Equal(t, c.exp, got, c.in)
+	}
+	_, err := CanonicalAddr("example.com")
+	require.Error(t, err)
+}
+
+func TestGameAddrFamily(t *testing.T) {
+	g := Game{Address: "1.2.3.4", AltAddress: "2001:db8::1"}
+	require.NoError(t, g.canonicalAddrs())
+	require.Equal(t, "1.2.3.4", g.AddrFor(AddrIPv4))
+	require.Equal(t, "2001:db8::1", g.AddrFor(AddrIPv6))
+	require.Equal(t, "1.2.3.4", g.AddrFor(AddrAny))
+
+	g.PreferAddr(AddrIPv6)
+	require.Equal(t, "2001:db8::1", g.Address)
+	require.Equal(t, "1.2.3.4", g.AltAddress)
+	g.PreferAddr(AddrIPv6)
+	require.Equal(t, "2001:db8::1", g.Address)
+
+	g = Game{Address: "1.2.3.4"}
+	require.Equal(t, "", g.AddrFor(AddrIPv6))
+	g.PreferAddr(AddrIPv6)
+	require.Equal(t, "1.2.3.4", g.Address)
+
+	g = Game{Address: "1.2.3.4", AltAddress: "::ffff:5.6.7.8"}
+	require.Error(t, g.canonicalAddrs())
+
+	f, err := ParseAddrFamily("6")
+	require.NoError(t, err)
+	require.Equal(t, AddrIPv6, f)
+	_, err = ParseAddrFamily("ipx")
+	require.Error(t, err)
+}
+
+func TestServiceCanonicalAddr(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+
+	g := Game{Name: "test", Address: "::ffff:1.2.3.4"}
+	testSetDefault(&g)
+	require.NoError(t, l.RegisterGame(ctx, &g))
+	g.Address = "1.2.3.4"
+	require.NoError(t, l.RegisterGame(ctx, &g))
+	g.Address = "2001:DB8::1"
+	g.AltAddress = "1.2.3.5"
+	require.NoError(t, l.RegisterGame(ctx, &g))
+	g.Address = "not an ip"
+	require.Error(t, l.RegisterGame(ctx, &g))
+
+	list, err := l.ListGames(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "1.2.3.4", list[0].Address)
+	require.Equal(t, "2001:db8::1", list[1].Address)
+	require.Equal(t, "1.2.3.5", list[1].AltAddress)
+
+	require.NoError(t, l.UnregisterGame(ctx, "2001:db8:0::1", 0))
+	list, err = l.ListGames(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+}
+
+func TestServerIPv6(t *testing.T) {
+	l := NewLobby()
+	api := NewServer(l)
+
+	g := Game{Name: "test", AltAddress: "1.2.3.4"}
+	testSetDefault(&g)
+	body, err := json.Marshal(g)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v0/games/register", bytes.NewReader(body))
+	req.RemoteAddr = "[2001:DB8::0:1]:1234"
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+	list, err := c.ListGames(context.Background())
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, "2001:db8::1", list[0].Address)
+	require.Equal(t, "1.2.3.4", list[0].AltAddress)
+
+	c.SetAddrFamily(AddrIPv4)
+	list, err = c.ListGames(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "1.2.3.4", list[0].Address)
+	require.Equal(t, "2001:db8::1", list[0].AltAddress)
+}
@@ -0,0 +1,358 @@
+package lobby
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Registration is a raw game registration stored by the Service, including expired registrations
+// that were not yet removed.
+type Registration struct {
+	GameInfo
+	ExpiresAt time.Time `json:"expires_at"`
+	Expired   bool      `json:"expired,omitempty"`
+	Banned    bool      `json:"banned,omitempty"`
+	HasToken  bool      `json:"has_token,omitempty"`
+}
+
+// Registrations returns all game registrations, including expired and banned ones.
+func (l *Service) Registrations() []Registration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	now := time.Now()
+	out := make([]Registration, 0, len(l.byAddr))
+	for key, v := range l.byAddr {
+		hasToken := l.tokens[key] != ""
+		out = append(out, Registration{
+			GameInfo:  *v.Clone(),
+			ExpiresAt: v.SeenAt.Add(l.timeout),
+			Expired:   !l.isValid(v, now),
+			Banned:    l.bans.Match(&v.Game) != nil,
+			HasToken:  hasToken,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return gameKeyLess(out[i].gameKey(), out[j].gameKey())
+	})
+	return out
+}
+
+// Timeout returns an expiration time for game registrations. See SetTimeout.
+func (l *Service) Timeout() time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.timeout
+}
+
+// ExpireGame removes the game registration immediately, regardless of registration tokens.
+// It returns false if there is no such registration.
+func (l *Service) ExpireGame(ctx context.Context, addr string, port int) (bool, error) {
+	if port <= 0 {
+		port = DefaultGamePort
+	}
+	ip, err := parseAddr(addr)
+	if err != nil {
+		return false, fmt.Errorf("invalid address: %w", err)
+	}
+	key := gameKey{Addr: ip, Port: port}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	prev := l.byAddr[key]
+	if prev == nil {
+		return false, nil
+	}
+	l.deleteGame(key, prev)
+	return true, nil
+}
+
+// SourceStats is a number of games and players listed from a single source.
+type SourceStats struct {
+	Name    string `json:"name"`
+	Games   int    `json:"games"`
+	Players int    `json:"players"`
+	Err     string `json:"error,omitempty"`
+}
+
+// TimeoutReq represents a request to change game registration timeout.
+type TimeoutReq struct {
+	// Timeout is a duration in Go format, for example "1m30s".
+	Timeout string `json:"timeout"`
+}
+
+// adminSource is a named game source for the admin API.
+type adminSource struct {
+	name string
+	l    Lister
+}
+
+// SetAdminKey enables admin API (/api/v0/admin/) protected by a given bearer key.
+// Admin API is disabled if the key is empty.
+func (api *Server) SetAdminKey(key string) {
+	api.adminKey = key
+}
+
+// SetAdminService sets a Service managed by the admin API. By default, the Lobby is used if it is a Service.
+func (api *Server) SetAdminService(s *Service) {
+	api.svc = s
+}
+
+// AddSource adds a named game source for the admin API. It will be included into source statistics,
+// and can be refreshed via the API, if it implements Refresher (see Cache).
+func (api *Server) AddSource(name string, l Lister) {
+	api.sources = append(api.sources, adminSource{name: name, l: l})
+}
+
+// SetBanList sets a ban list managed by the admin API.
+// By default, the Lobby's ban list is used, if any (see Service.SetBanList).
+func (api *Server) SetBanList(b *BanList) {
+	api.bans = b
+}
+
+// banList returns a ban list managed by the admin API, or nil if there is none.
+func (api *Server) banList() *BanList {
+	if api.bans != nil {
+		return api.bans
+	}
+	if api.lobbyBans != nil {
+		return api.lobbyBans.BanList()
+	}
+	return nil
+}
+
+// checkAdmin checks the admin key. If the request is not authorized, an error response is written and false is returned.
+func (api *Server) checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if api.adminKey == "" {
+		api.jsonError(w, http.StatusNotFound, fmt.Errorf("admin API is disabled: %w", ErrNotFound))
+		return false
+	}
+	if !tokenEqual(bearerToken(r), api.adminKey) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		api.jsonError(w, http.StatusUnauthorized, fmt.Errorf("invalid admin key: %w", ErrUnauthorized))
+		return false
+	}
+	return true
+}
+
+// AdminBans lists (GET), adds (POST) or removes (DELETE with id parameter) ban list entries.
+func (api *Server) AdminBans(w http.ResponseWriter, r *http.Request) {
+	if !api.checkAdmin(w, r) {
+		return
+	}
+	bans := api.banList()
+	if bans == nil {
+		api.jsonError(w, http.StatusNotImplemented, fmt.Errorf("ban list is disabled: %w", ErrNotImplemented))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		api.jsonResponse(w, 0, bans.Bans())
+	case http.MethodPost:
+		body := http.MaxBytesReader(w, r.Body, 64*1024)
+		var req Ban
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			api.jsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		ban, err := bans.AddBan(req)
+		if err != nil {
+			api.jsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		api.jsonResponse(w, 0, ban)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			api.jsonError(w, http.StatusBadRequest, errors.New("ban id must be set"))
+			return
+		}
+		ok, err := bans.RemoveBan(id)
+		if err != nil {
+			api.jsonError(w, http.StatusInternalServerError, err)
+			return
+		} else if !ok {
+			api.jsonError(w, http.StatusNotFound, fmt.Errorf("ban %w", ErrNotFound))
+			return
+		}
+		api.jsonResponse(w, 0, nil)
+	default:
+		api.jsonError(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// adminService returns a Service for the admin API. If it's not set, an error response is written and nil is returned.
+func (api *Server) adminService(w http.ResponseWriter) *Service {
+	if api.svc == nil {
+		api.jsonError(w, http.StatusNotImplemented, fmt.Errorf("lobby service is not available: %w", ErrNotImplemented))
+	}
+	return api.svc
+}
+
+// AdminGames lists all game registrations, including expired ones.
+func (api *Server) AdminGames(w http.ResponseWriter, r *http.Request) {
+	if !api.checkAdmin(w, r) {
+		return
+	}
+	svc := api.adminService(w)
+	if svc == nil {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		api.jsonResponse(w, 0, svc.Registrations())
+	default:
+		api.jsonError(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// AdminExpireGame removes the game registration immediately.
+func (api *Server) AdminExpireGame(w http.ResponseWriter, r *http.Request) {
+	if !api.checkAdmin(w, r) {
+		return
+	}
+	svc := api.adminService(w)
+	if svc == nil {
+		return
+	}
+	switch r.Method {
+	case http.MethodPost, http.MethodDelete:
+		body := http.MaxBytesReader(w, r.Body, 1024)
+		var req UnregisterReq
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			api.jsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		ok, err := svc.ExpireGame(r.Context(), req.Address, req.Port)
+		if err != nil {
+			api.jsonError(w, http.StatusBadRequest, err)
+			return
+		} else if !ok {
+			api.jsonError(w, http.StatusNotFound, fmt.Errorf("game %w", ErrNotFound))
+			return
+		}
+		api.jsonResponse(w, 0, nil)
+	default:
+		api.jsonError(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// AdminTimeout returns (GET) or changes (POST, PUT) the game registration timeout.
+func (api *Server) AdminTimeout(w http.ResponseWriter, r *http.Request) {
+	if !api.checkAdmin(w, r) {
+		return
+	}
+	svc := api.adminService(w)
+	if svc == nil {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost, http.MethodPut:
+		body := http.MaxBytesReader(w, r.Body, 1024)
+		var req TimeoutReq
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			api.jsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		dt, err := time.ParseDuration(req.Timeout)
+		if err != nil || dt <= 0 {
+			api.jsonError(w, http.StatusBadRequest, errors.New("invalid timeout value"))
+			return
+		}
+		svc.SetTimeout(dt)
+	default:
+		api.jsonError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	api.jsonResponse(w, 0, TimeoutReq{Timeout: svc.Timeout().String()})
+}
+
+// AdminSources returns game statistics for each source.
+func (api *Server) AdminSources(w http.ResponseWriter, r *http.Request) {
+	if !api.checkAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		api.jsonResponse(w, 0, api.sourceStats(r.Context(), "", false))
+	default:
+		api.jsonError(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// AdminRefresh refreshes game lists of all cached sources, or only of the one set in the source parameter.
+func (api *Server) AdminRefresh(w http.ResponseWriter, r *http.Request) {
+	if !api.checkAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		name := r.URL.Query().Get("source")
+		stats := api.sourceStats(r.Context(), name, true)
+		if name != "" && len(stats) == 0 {
+			if api.hasSource(name) {
+				api.jsonError(w, http.StatusBadRequest, fmt.Errorf("source %q cannot be refreshed", name))
+			} else {
+				api.jsonError(w, http.StatusNotFound, fmt.Errorf("source %w", ErrNotFound))
+			}
+			return
+		}
+		api.jsonResponse(w, 0, stats)
+	default:
+		api.jsonError(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// hasSource checks if the admin API has a source with a given name.
+func (api *Server) hasSource(name string) bool {
+	if api.svc != nil && name == sourceOpenNox {
+		return true
+	}
+	for _, src := range api.sources {
+		if src.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceStats collects game statistics for sources. If name is set, only this source is checked.
+// If refresh is set, only sources that implement Refresher are checked and their caches are refreshed.
+func (api *Server) sourceStats(ctx context.Context, name string, refresh bool) []SourceStats {
+	sources := api.sources
+	if api.svc != nil {
+		sources = append([]adminSource{{name: sourceOpenNox, l: api.svc}}, sources...)
+	}
+	out := make([]SourceStats, 0, len(sources))
+	for _, src := range sources {
+		if name != "" && src.name != name {
+			continue
+		}
+		var (
+			list []GameInfo
+			err  error
+		)
+		if refresh {
+			rf, ok := src.l.(Refresher)
+			if !ok {
+				continue
+			}
+			list, err = rf.RefreshGames(ctx)
+		} else {
+			list, err = src.l.ListGames(ctx)
+		}
+		st := SourceStats{Name: src.name, Games: len(list)}
+		for _, g := range list {
+			st.Players += g.Players.Cur
+		}
+		if err != nil {
+			st.Err = err.Error()
+		}
+		out = append(out, st)
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+package lobby
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingLister counts ListGames calls.
+type countingLister struct {
+	Lister
+	calls int32
+}
+
+func (l *countingLister) ListGames(ctx context.Context) ([]GameInfo, error) {
+	atomic.AddInt32(&l.calls, 1)
+	return l.Lister.ListGames(ctx)
+}
+
+func TestAdminAPI(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	l.SetTimeout(time.Hour)
+	base := NewLobby()
+	registerServer(t, base, initServers[3])
+	cnt := &countingLister{Lister: base}
+	cached := Cache(cnt, time.Hour)
+
+	api := NewServer(Overlay(l, cached))
+	api.SetAdminService(l)
+	api.AddSource("base", cached)
+	api.SetAdminKey("secret")
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+	admin := func(meth, path string, body, out interface{}) error {
+		return c.doRequest(ctx, meth, path, "secret", body, &Response{Result: out})
+	}
+
+	g1, g2 := initServers[0], initServers[1]
+	g1.Players.Cur = 3
+	registerServer(t, l, g1)
+	registerServer(t, l, g2)
+
+	// live timeout change
+	var tm TimeoutReq
+	require.NoError(t, admin("GET", "/api/v0/admin/timeout", nil, &tm))
+	require.Equal(t, "1h0m0s", tm.Timeout)
+	require.Error(t, admin("PUT", "/api/v0/admin/timeout", TimeoutReq{Timeout: "bad"}, nil))
+	require.NoError(t, admin("PUT", "/api/v0/admin/timeout", TimeoutReq{Timeout: "30ms"}, &tm))
+	require.Equal(t, "30ms", tm.Timeout)
+	require.Equal(t, 30*time.Millisecond, l.Timeout())
+
+	// expired games are still visible to admins until removed by GC
+	time.Sleep(40 * time.Millisecond)
+	var regs []Registration
+	require.NoError(t, admin("GET", "/api/v0/admin/games", nil, &regs))
+	require.Len(t, regs, 2)
+	require.True(t, regs[0].Expired)
+	require.Equal(t, g1.Name, regs[0].Name)
+
+	// games are valid again with a longer timeout
+	l.SetTimeout(time.Hour)
+	regs = nil
+	require.NoError(t, admin("GET", "/api/v0/admin/games", nil, &regs))
+	require.Len(t, regs, 2)
+	require.False(t, regs[0].Expired)
+
+	// force expire
+	require.NoError(t, admin("POST", "/api/v0/admin/games/expire", UnregisterReq{Address: g1.Address, Port: g1.Port}, nil))
+	require.Error(t, admin("POST", "/api/v0/admin/games/expire", UnregisterReq{Address: g1.Address, Port: g1.Port}, nil))
+	expectServers(t, l, []Game{g2})
+
+	registerServer(t, l, g1)
+	var stats []SourceStats
+	require.NoError(t, admin("GET", "/api/v0/admin/sources", nil, &stats))
+	require.Equal(t, []SourceStats{
+		{Name: "opennox", Games: 2, Players: 3},
+		{Name: "base", Games: 1},
+	}, stats)
+
+	// refresh bypasses the cache
+	calls := atomic.LoadInt32(&cnt.calls)
+	registerServer(t, base, initServers[4])
+	require.NoError(t, admin("POST", "/api/v0/admin/refresh", nil, &stats))
+	require.Equal(t, []SourceStats{{Name: "base", Games: 2}}, stats)
+	require.Equal(t, calls+1, atomic.LoadInt32(&cnt.calls))
+	list, err := cached.ListGames(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	err = admin("POST", "/api/v0/admin/refresh?source=opennox", nil, nil)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, admin("POST", "/api/v0/admin/refresh?source=unknown", nil, nil), ErrNotFound)
+
+	// wrong key
+	err = c.doRequest(ctx, "GET", "/api/v0/admin/games", "bad", nil, &Response{})
+	require.Error(t, err)
+}
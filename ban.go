@@ -0,0 +1,316 @@
+package lobby
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBanReloadInterval is an interval for checking the ban list file for changes.
+const DefaultBanReloadInterval = 10 * time.Second
+
+// ErrBanned is returned when the game registration is rejected by the ban list.
+var ErrBanned = errors.New("game is banned")
+
+// BanKind is a kind of the ban list entry.
+type BanKind string
+
+const (
+	// BanAddr bans games by IP address or CIDR.
+	BanAddr = BanKind("addr")
+	// BanName bans games with names matching the regular expression.
+	BanName = BanKind("name")
+	// BanMap bans games by map name (case-insensitive).
+	BanMap = BanKind("map")
+)
+
+// Ban is a single entry in the BanList.
+type Ban struct {
+	ID    string  `json:"id"`
+	Kind  BanKind `json:"kind"`
+	Value string  `json:"value"`
+	// Mute hides matching games from the list, but accepts their registrations without an error.
+	Mute    bool      `json:"mute,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// compiledBan is a Ban prepared for matching.
+type compiledBan struct {
+	Ban
+	prefix netip.Prefix
+	re     *regexp.Regexp
+}
+
+func compileBan(b Ban) (*compiledBan, error) {
+	c := &compiledBan{Ban: b}
+	switch b.Kind {
+	case BanAddr:
+		p, err := parsePrefix(b.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ban address: %w", err)
+		}
+		c.prefix = p
+	case BanName:
+		re, err := regexp.Compile(b.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ban name regexp: %w", err)
+		}
+		c.re = re
+	case BanMap:
+		if strings.TrimSpace(b.Value) == "" {
+			return nil, errors.New("ban map must be set")
+		}
+	default:
+		return nil, fmt.Errorf("unknown ban kind: %q", b.Kind)
+	}
+	return c, nil
+}
+
+func (b *compiledBan) match(g *Game) bool {
+	switch b.Kind {
+	case BanAddr:
+		for _, s := range []string{g.Address, g.AltAddress} {
+			if a, err := parseAddr(s); err == nil && b.prefix.Contains(a) {
+				return true
+			}
+		}
+	case BanName:
+		return b.re.MatchString(g.Name)
+	case BanMap:
+		return strings.EqualFold(g.Map, b.Value)
+	}
+	return false
+}
+
+// NewBanList creates an empty in-memory ban list.
+func NewBanList() *BanList {
+	return &BanList{}
+}
+
+// OpenBanList opens a ban list persisted to a JSON file. The file is created on the first change, if it doesn't exist.
+// See Watch for reloading the file when it's edited by hand.
+func OpenBanList(path string) (*BanList, error) {
+	b := &BanList{path: path}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// BanList is a list of banned game addresses, names and maps, managed by the lobby administrator.
+// It can be used with Service.SetBanList. It is safe for concurrent use.
+type BanList struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	bans    []*compiledBan
+}
+
+// Bans returns all entries in the ban list.
+func (b *BanList) Bans() []Ban {
+	if b == nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := make([]Ban, 0, len(b.bans))
+	for _, c := range b.bans {
+		out = append(out, c.Ban)
+	}
+	return out
+}
+
+// Match returns the first ban list entry matching the game, or nil if the game is not banned.
+func (b *BanList) Match(g *Game) *Ban {
+	if b == nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, c := range b.bans {
+		if c.match(g) {
+			ban := c.Ban
+			return &ban
+		}
+	}
+	return nil
+}
+
+// filterGames removes banned games from the list in place.
+func (b *BanList) filterGames(list []GameInfo) []GameInfo {
+	if b == nil {
+		return list
+	}
+	out := list[:0]
+	for _, g := range list {
+		if b.Match(&g.Game) == nil {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// AddBan validates and adds a new entry to the ban list. ID and creation time are set automatically, if not set.
+func (b *BanList) AddBan(ban Ban) (*Ban, error) {
+	if ban.ID == "" {
+		var id [8]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return nil, err
+		}
+		ban.ID = hex.EncodeToString(id[:])
+	}
+	if ban.Created.IsZero() {
+		ban.Created = time.Now().UTC()
+	}
+	c, err := compileBan(ban)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c2 := range b.bans {
+		if c2.ID == ban.ID {
+			return nil, fmt.Errorf("ban %q already exists", ban.ID)
+		}
+	}
+	bans := append(b.bans[:len(b.bans):len(b.bans)], c)
+	if err := b.save(bans); err != nil {
+		return nil, err
+	}
+	b.bans = bans
+	return &ban, nil
+}
+
+// RemoveBan removes the entry from the ban list. It returns false if there is no entry with this ID.
+func (b *BanList) RemoveBan(id string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, c := range b.bans {
+		if c.ID != id {
+			continue
+		}
+		bans := make([]*compiledBan, 0, len(b.bans)-1)
+		bans = append(bans, b.bans[:i]...)
+		bans = append(bans, b.bans[i+1:]...)
+		if err := b.save(bans); err != nil {
+			return false, err
+		}
+		b.bans = bans
+		return true, nil
+	}
+	return false, nil
+}
+
+// save writes the list to the file. It must be called with the lock held.
+func (b *BanList) save(bans []*compiledBan) error {
+	if b.path == "" {
+		return nil
+	}
+	list := make([]Ban, 0, len(bans))
+	for _, c := range bans {
+		list = append(list, c.Ban)
+	}
+	data, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(b.path); err == nil {
+		b.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// Reload reads the ban list from the file. It does nothing for in-memory ban lists.
+func (b *BanList) Reload() error {
+	if b.path == "" {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reload()
+}
+
+// reload must be called with the lock held.
+func (b *BanList) reload() error {
+	data, err := os.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		b.bans, b.modTime = nil, time.Time{}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var list []Ban
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("cannot parse ban list %q: %w", filepath.Base(b.path), err)
+	}
+	bans := make([]*compiledBan, 0, len(list))
+	for _, ban := range list {
+		c, err := compileBan(ban)
+		if err != nil {
+			return fmt.Errorf("invalid ban %q: %w", ban.ID, err)
+		}
+		bans = append(bans, c)
+	}
+	b.bans = bans
+	if fi, err := os.Stat(b.path); err == nil {
+		b.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// Watch checks the ban list file for changes with a given interval and reloads it, until the context is canceled.
+// If the interval is zero, DefaultBanReloadInterval is used.
+func (b *BanList) Watch(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultBanReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if b.path == "" {
+			continue
+		}
+		fi, err := os.Stat(b.path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			log.Printf("bans: %v", err)
+			continue
+		}
+		b.mu.Lock()
+		if !fi.ModTime().Equal(b.modTime) {
+			if err := b.reload(); err != nil {
+				// keep the old list, but do not retry until the file changes again
+				b.modTime = fi.ModTime()
+				log.Printf("bans: cannot reload: %v", err)
+			} else {
+				log.Printf("bans: reloaded %d entries", len(b.bans))
+			}
+		}
+		b.mu.Unlock()
+	}
+}
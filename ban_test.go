@@ -0,0 +1,150 @@
+package lobby
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBanList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	b, err := OpenBanList(path)
+	require.NoError(t, err)
+	require.Empty(t, b.Bans())
+
+	_, err = b.AddBan(Ban{Kind: BanAddr, Value: "bad"})
+	require.Error(t, err)
+	_, err = b.AddBan(Ban{Kind: BanName, Value: "("})
+	require.Error(t, err)
+	_, err = b.AddBan(Ban{Kind: "unknown", Value: "x"})
+	require.Error(t, err)
+
+	ban1, err := b.AddBan(Ban{Kind: BanAddr, Value: "10.0.0.0/8"})
+	require.NoError(t, err)
+	require.NotEmpty(t, ban1.ID)
+	_, err = b.AddBan(Ban{Kind: BanName, Value: "(?i)spam"})
+	require.NoError(t, err)
+	_, err = b.AddBan(Ban{Kind: BanMap, Value: "Estate", Mute: true})
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		g    Game
+		kind BanKind
+	}{
+		{Game{Address: "10.1.2.3", Name: "ok", Map: "bunker"}, BanAddr},
+		{Game{Address: "2001:db8::1", AltAddress: "10.1.2.3", Name: "ok", Map: "bunker"}, BanAddr},
+		{Game{Address: "1.1.1.1", Name: "Best SPAM server", Map: "bunker"}, BanName},
+		{Game{Address: "1.1.1.1", Name: "ok", Map: "estate"}, BanMap},
+		{Game{Address: "1.1.1.1", Name: "ok", Map: "bunker"}, ""},
+	} {
+		ban := b.Match(&c.g)
+		if c.kind == "" {
+			require.Nil(t, ban)
+		} else {
+			require.NotNil(t, ban)
+			require.Equal(t, c.kind, ban.Kind)
+		}
+	}
+
+	// persisted
+	b2, err := OpenBanList(path)
+	require.NoError(t, err)
+	require.Equal(t, b.Bans(), b2.Bans())
+
+	ok, err := b.RemoveBan(ban1.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = b.RemoveBan(ban1.ID)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Len(t, b.Bans(), 2)
+
+	// hot reload
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b2.Watch(ctx, testTimeout)
+	now := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, now, now))
+	require.Eventually(t, func() bool {
+		return len(b2.Bans()) == 2
+	}, time.Second, testTimeout)
+}
+
+func TestServiceBans(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	b := NewBanList()
+	l.SetBanList(b)
+
+	g1, g2, g3 := initServers[0], initServers[1], initServers[2]
+	registerServer(t, l, g1)
+	registerServer(t, l, g2)
+	registerServer(t, l, g3)
+	expectServers(t, l, []Game{g1, g2, g3})
+
+	_, err := b.AddBan(Ban{Kind: BanAddr, Value: g1.Address})
+	require.NoError(t, err)
+	_, err = b.AddBan(Ban{Kind: BanName, Value: "^" + g2.Name + "$", Mute: true})
+	require.NoError(t, err)
+	// banned games are hidden immediately
+	expectServers(t, l, []Game{g3})
+
+	err = l.RegisterGame(ctx, g1.Clone())
+	require.ErrorIs(t, err, ErrBanned)
+	err = l.RegisterGame(ctx, g2.Clone())
+	require.NoError(t, err)
+	expectServers(t, l, []Game{g3})
+
+	// banned games are removed
+	l.SetBanList(nil)
+	expectServers(t, l, []Game{g3})
+
+	// bans are applied to the base games of the overlay
+	l.SetBanList(b)
+	base := NewLobby()
+	registerServer(t, base, g1)
+	registerServer(t, base, initServers[3])
+	expectServers(t, Overlay(l, base), []Game{g3, initServers[3]})
+}
+
+func TestAdminBans(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	api := NewServer(l)
+	// ban list set after creating the server must be used
+	l.SetBanList(NewBanList())
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	var bans []Ban
+	err := c.sendRequest(ctx, "GET", "/api/v0/admin/bans", nil, &bans)
+	require.Error(t, err)
+
+	api.SetAdminKey("secret")
+	err = c.sendRequest(ctx, "GET", "/api/v0/admin/bans", nil, &bans)
+	require.Error(t, err)
+
+	var ban Ban
+	err = c.doRequest(ctx, "POST", "/api/v0/admin/bans", "secret", Ban{Kind: BanAddr, Value: "1.1.1.1"}, &Response{Result: &ban})
+	require.NoError(t, err)
+	require.NotEmpty(t, ban.ID)
+
+	err = c.doRequest(ctx, "GET", "/api/v0/admin/bans", "secret", nil, &Response{Result: &bans})
+	require.NoError(t, err)
+	require.Equal(t, []Ban{ban}, bans)
+
+	err = l.RegisterGame(ctx, server1.Clone())
+	require.ErrorIs(t, err, ErrBanned)
+
+	err = c.doRequest(ctx, "DELETE", "/api/v0/admin/bans?id="+ban.ID, "secret", nil, &Response{})
+	require.NoError(t, err)
+	err = c.doRequest(ctx, "DELETE", "/api/v0/admin/bans?id="+ban.ID, "secret", nil, &Response{})
+	require.Error(t, err)
+	registerServer(t, l, server1)
+}
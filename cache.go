@@ -2,59 +2,244 @@ package lobby
 
 import (
 	"context"
+	"log"
 	"sync"
 	"time"
 )
 
+// DefaultCacheMaxStale is a default max staleness of the game list served by Cache when the underlying Lister fails.
+const DefaultCacheMaxStale = 5 * time.Minute
+
+// CacheOptions configures CacheWith.
+type CacheOptions struct {
+	// Name of the cache for metrics and logs.
+	Name string
+	// Expire controls how long the cached list is considered fresh. Default is DefaultTimeout/2.
+	Expire time.Duration
+	// RefreshAfter is an age of the cached list after which it is refreshed in background, while the cached list
+	// is still returned to callers. Default is 3/4 of Expire. Background refresh is disabled if it's not less than Expire.
+	RefreshAfter time.Duration
+	// MaxStale is a max time after expiration when the cached list is still returned, while it is refreshed
+	// in background, or if the underlying Lister fails. Zero disables serving stale lists.
+	MaxStale time.Duration
+	// FetchTimeout is a timeout for listing games from the underlying Lister. Default is DefaultTimeout/3.
+	FetchTimeout time.Duration
+}
+
 // Cache creates a cache over a game Lister.
 // Expiration time controls how often the cache is invalidated.
+//
+// The list is refreshed in background before it expires, and a stale list is returned for up to
+// DefaultCacheMaxStale if the Lister fails. See CacheWith for more options.
 func Cache(l Lister, expire time.Duration) Lister {
-	if expire == 0 {
-		expire = DefaultTimeout / 2
+	return CacheWith(l, CacheOptions{Expire: expire, MaxStale: DefaultCacheMaxStale})
+}
+
+// CacheWith creates a cache over a game Lister with given options.
+//
+// Concurrent requests for an expired list result in a single call to the Lister.
+// After the Lister fails, it is not called again until the backoff time passes, see cacheRetry.
+func CacheWith(l Lister, opts CacheOptions) Lister {
+	if opts.Expire <= 0 {
+		opts.Expire = DefaultTimeout / 2
+	}
+	if opts.RefreshAfter <= 0 {
+		opts.RefreshAfter = opts.Expire * 3 / 4
+	}
+	if opts.FetchTimeout <= 0 {
+		opts.FetchTimeout = DefaultTimeout / 3
 	}
-	return &listCache{l: l, exp: expire}
+	return &listCache{l: l, opts: opts}
 }
 
+// cacheRetry controls how often the cache calls the Lister after failures.
+var cacheRetry = RetryPolicy{MinBackoff: time.Second, MaxBackoff: time.Minute}
+
 type listCache struct {
-	l   Lister
-	exp time.Duration
+	l    Lister
+	opts CacheOptions
 
-	mu   sync.RWMutex
+	mu   sync.Mutex
 	last time.Time
 	list []GameInfo
+	call *cacheCall
+
+	failures int
+	err      error     // last error of the Lister
+	retryAt  time.Time // the Lister is not called before this time after failures
+}
+
+// cacheCall is a single in-flight call to the underlying Lister.
+type cacheCall struct {
+	done chan struct{}
+	list []GameInfo
+	err  error
+}
+
+// detachedContext keeps values of the parent context, but not its deadline and cancellation.
+type detachedContext struct {
+	context.Context
 }
 
-func (l *listCache) listGames(ctx context.Context) ([]GameInfo, error) {
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// fetchLocked starts a call to the underlying Lister, or returns the one that is already in flight.
+// The call is not canceled with the context. It must be called with the lock held.
+func (l *listCache) fetchLocked(ctx context.Context) *cacheCall {
+	if l.call != nil {
+		return l.call
+	}
+	c := &cacheCall{done: make(chan struct{})}
+	l.call = c
+	go func() {
+		defer close(c.done)
+		fctx, cancel := context.WithTimeout(detachedContext{ctx}, l.opts.FetchTimeout)
+		defer cancel()
+		c.list, c.err = l.l.ListGames(fctx)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.call = nil
+		if c.err != nil {
+			if l.opts.Name != "" {
+				log.Printf("cache %q: %v", l.opts.Name, c.err)
+			}
+			l.failures++
+			l.err = c.err
+			l.retryAt = time.Now().Add(cacheRetry.backoff(l.failures))
+			return
+		}
+		l.failures, l.err, l.retryAt = 0, nil, time.Time{}
+		l.list, l.last = c.list, time.Now()
+	}()
+	return c
+}
+
+// wait for the call to complete or for the context to be canceled.
+func (c *cacheCall) wait(ctx context.Context) ([]GameInfo, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return c.list, c.err
+	}
+}
+
+// listGames returns the cached list and its status, fetching it if needed.
+//
+// Stale lists are returned immediately, while the new list is fetched in background.
+// Callers only wait for the Lister if there's no list to return.
+func (l *listCache) listGames(ctx context.Context) ([]GameInfo, ListStatus, error) {
 	now := time.Now()
 	l.mu.Lock()
-	defer l.mu.Unlock()
-	if l.last.Add(l.exp).After(now) {
-		return l.list, nil
+	age := now.Sub(l.last)
+	has := !l.last.IsZero()
+	list, last := l.list, l.last
+	if has && age < l.opts.Expire {
+		if age >= l.opts.RefreshAfter && !now.Before(l.retryAt) {
+			l.fetchLocked(ctx)
+		}
+		l.mu.Unlock()
+		cntCache.WithLabelValues(l.opts.Name, "hit").Inc()
+		return list, ListStatus{Updated: last}, nil
+	}
+	if has && age < l.opts.Expire+l.opts.MaxStale {
+		if !now.Before(l.retryAt) {
+			l.fetchLocked(ctx)
+		}
+		err := l.err
+		l.mu.Unlock()
+		cntCache.WithLabelValues(l.opts.Name, "stale").Inc()
+		return list, ListStatus{Updated: last, Stale: true, Err: err}, nil
+	}
+	if l.err != nil && now.Before(l.retryAt) {
+		// do not block callers while the Lister is failing
+		err := l.err
+		l.mu.Unlock()
+		cntCache.WithLabelValues(l.opts.Name, "error").Inc()
+		return nil, ListStatus{}, err
+	}
+	c := l.fetchLocked(ctx)
+	l.mu.Unlock()
+	fresh, err := c.wait(ctx)
+	if err == nil {
+		cntCache.WithLabelValues(l.opts.Name, "miss").Inc()
+		return fresh, ListStatus{Updated: time.Now()}, nil
 	}
-	list, err := l.l.ListGames(ctx)
+	cntCache.WithLabelValues(l.opts.Name, "error").Inc()
+	return nil, ListStatus{}, err
+}
+
+func (l *listCache) ListGames(ctx context.Context) ([]GameInfo, error) {
+	list, _, err := l.listGames(ctx)
 	if err != nil {
 		return nil, err
 	}
-	l.list, l.last = list, now
-	return l.list, nil
+	return cloneGames(list), nil
 }
 
-func (l *listCache) ListGames(ctx context.Context) ([]GameInfo, error) {
-	now := time.Now()
-	l.mu.RLock()
-	ok := l.last.Add(l.exp).After(now)
-	list := l.list
-	l.mu.RUnlock()
-	if !ok {
-		var err error
-		list, err = l.listGames(ctx)
-		if err != nil {
-			return nil, err
-		}
+var _ StatusLister = (*listCache)(nil)
+
+// ListGamesStatus implements StatusLister.
+func (l *listCache) ListGamesStatus(ctx context.Context) ([]GameInfo, ListStatus, error) {
+	list, st, err := l.listGames(ctx)
+	if err != nil {
+		return nil, st, err
+	}
+	return cloneGames(list), st, nil
+}
+
+// ListStatus describes freshness of the game list.
+type ListStatus struct {
+	// Updated is the time when the list was fetched from the source. It's zero if unknown.
+	Updated time.Time
+	// Stale is set if the list is expired, but returned while it is being refreshed, or because the source
+	// failed with Err.
+	Stale bool
+	Err   error
+}
+
+// StatusLister is implemented by Listers that report freshness of game lists, see Cache.
+type StatusLister interface {
+	// ListGamesStatus is similar to Lister.ListGames, but also returns the status of the list.
+	ListGamesStatus(ctx context.Context) ([]GameInfo, ListStatus, error)
+}
+
+// listGamesStatus lists games and reports the status of the list, if the Lister supports it.
+func listGamesStatus(ctx context.Context, l Lister) ([]GameInfo, ListStatus, error) {
+	if sl, ok := l.(StatusLister); ok {
+		return sl.ListGamesStatus(ctx)
 	}
+	list, err := l.ListGames(ctx)
+	return list, ListStatus{}, err
+}
+
+// cloneGames makes a deep copy of the game list.
+func cloneGames(list []GameInfo) []GameInfo {
 	out := make([]GameInfo, 0, len(list))
 	for _, g := range list {
 		out = append(out, *g.Clone())
 	}
-	return out, nil
+	return out
+}
+
+// Refresher is implemented by Listers that cache the game list, see Cache.
+type Refresher interface {
+	// RefreshGames fetches a new game list, bypassing the cache.
+	RefreshGames(ctx context.Context) ([]GameInfo, error)
+}
+
+var _ Refresher = (*listCache)(nil)
+
+// RefreshGames implements Refresher.
+func (l *listCache) RefreshGames(ctx context.Context) ([]GameInfo, error) {
+	l.mu.Lock()
+	c := l.fetchLocked(ctx)
+	l.mu.Unlock()
+	list, err := c.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cloneGames(list), nil
 }
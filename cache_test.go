@@ -0,0 +1,177 @@
+package lobby
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// slowLister returns a single game, or an error if set. Calls are blocked until the gate is closed, if set.
+type slowLister struct {
+	mu    sync.Mutex
+	gate  chan struct{}
+	err   error
+	calls int32
+}
+
+func (l *slowLister) set(gate chan struct{}, err error) {
+	l.mu.Lock()
+	l.gate, l.err = gate, err
+	l.mu.Unlock()
+}
+
+func (l *slowLister) ListGames(ctx context.Context) ([]GameInfo, error) {
+	n := atomic.AddInt32(&l.calls, 1)
+	l.mu.Lock()
+	gate, err := l.gate, l.err
+	l.mu.Unlock()
+	if gate != nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-gate:
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	g := GameInfo{Game: *server1.Clone()}
+	g.Players.Cur = int(n)
+	return []GameInfo{g}, nil
+}
+
+// cacheCount returns a value of the cache metric.
+func cacheCount(name, result string) int {
+	return int(testutil.ToFloat64(cntCache.WithLabelValues(name, result)))
+}
+
+func TestCacheSingleflight(t *testing.T) {
+	ctx := context.Background()
+	misses := cacheCount("test-singleflight", "miss")
+	sl := &slowLister{}
+	gate := make(chan struct{})
+	sl.set(gate, nil)
+	c := CacheWith(sl, CacheOptions{Name: "test-singleflight", Expire: time.Hour})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			list, err := c.ListGames(ctx)
+			require.NoError(t, err)
+			require.Len(t, list, 1)
+		}()
+	}
+	time.Sleep(testTimeout)
+	close(gate)
+	wg.Wait()
+	require.EqualValues(t, 1, atomic.LoadInt32(&sl.calls))
+	require.Equal(t, misses+10, cacheCount("test-singleflight", "miss"))
+
+	// canceled callers do not cancel the call
+	gate = make(chan struct{})
+	sl.set(gate, nil)
+	cctx, cancel := context.WithTimeout(ctx, testTimeout)
+	defer cancel()
+	_, err := c.(Refresher).RefreshGames(cctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	close(gate)
+	require.Eventually(t, func() bool {
+		list, err := c.ListGames(ctx)
+		require.NoError(t, err)
+		return list[0].Players.Cur == 2
+	}, time.Second, testTimeout)
+	require.EqualValues(t, 2, atomic.LoadInt32(&sl.calls))
+}
+
+func TestCacheRefreshAhead(t *testing.T) {
+	ctx := context.Background()
+	sl := &slowLister{}
+	c := CacheWith(sl, CacheOptions{Name: "test-refresh", Expire: time.Hour, RefreshAfter: time.Nanosecond})
+	hits := cacheCount("test-refresh", "hit")
+
+	list, err := c.ListGames(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, list[0].Players.Cur)
+
+	// cached list is returned, while the new one is fetched in background
+	gate := make(chan struct{})
+	sl.set(gate, nil)
+	list, err = c.ListGames(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, list[0].Players.Cur)
+	close(gate)
+	require.Eventually(t, func() bool {
+		list, err := c.ListGames(ctx)
+		require.NoError(t, err)
+		return list[0].Players.Cur > 1
+	}, time.Second, testTimeout)
+	require.True(t, cacheCount("test-refresh", "hit") >= hits+2)
+}
+
+func TestCacheStale(t *testing.T) {
+	ctx := context.Background()
+	sl := &slowLister{}
+	c := CacheWith(sl, CacheOptions{Name: "test-stale", Expire: time.Hour, MaxStale: time.Hour})
+	lc := c.(*listCache)
+	stale, errs := cacheCount("test-stale", "stale"), cacheCount("test-stale", "error")
+	setAge := func(d time.Duration) {
+		lc.mu.Lock()
+		lc.last = time.Now().Add(-d)
+		lc.mu.Unlock()
+	}
+
+	_, err := c.ListGames(ctx)
+	require.NoError(t, err)
+
+	// stale list is returned immediately, while it is refreshed in background
+	errFail := errors.New("unavailable")
+	gate := make(chan struct{})
+	sl.set(gate, errFail)
+	setAge(90 * time.Minute)
+	list, st, err := lc.ListGamesStatus(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.True(t, st.Stale)
+	require.Equal(t, stale+1, cacheCount("test-stale", "stale"))
+	close(gate)
+	require.Eventually(t, func() bool {
+		_, st, err := lc.ListGamesStatus(ctx)
+		require.NoError(t, err)
+		return st.Err != nil
+	}, time.Second, testTimeout/10)
+
+	// failed Lister is not called again until the backoff passes
+	calls := atomic.LoadInt32(&sl.calls)
+	_, st, err = lc.ListGamesStatus(ctx)
+	require.NoError(t, err)
+	require.ErrorIs(t, st.Err, errFail)
+	require.EqualValues(t, calls, atomic.LoadInt32(&sl.calls))
+
+	// stale list is not returned for too long, but callers are not blocked by the failing Lister either
+	setAge(3 * time.Hour)
+	_, err = c.ListGames(ctx)
+	require.ErrorIs(t, err, errFail)
+	require.Equal(t, errs+1, cacheCount("test-stale", "error"))
+	require.EqualValues(t, calls, atomic.LoadInt32(&sl.calls))
+
+	// refresh bypasses stale lists and the backoff
+	setAge(90 * time.Minute)
+	_, err = c.(Refresher).RefreshGames(ctx)
+	require.ErrorIs(t, err, errFail)
+	require.EqualValues(t, calls+1, atomic.LoadInt32(&sl.calls))
+
+	sl.set(nil, nil)
+	_, err = c.(Refresher).RefreshGames(ctx)
+	require.NoError(t, err)
+	_, st, err = lc.ListGamesStatus(ctx)
+	require.NoError(t, err)
+	require.False(t, st.Stale)
+}
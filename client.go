@@ -1,21 +1,42 @@
 package lobby
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
+	"sync"
+	"time"
 )
 
-var _ Lobby = &Client{}
+var (
+	_ Lobby             = &Client{}
+	_ Watcher           = &Client{}
+	_ TokenRegisterer   = &Client{}
+	_ Unregisterer      = &Client{}
+	_ TokenUnregisterer = &Client{}
+	_ PlayerFinder      = &Client{}
+	_ SourceLister      = &Client{}
+)
 
 // Client is an HTTP Nox lobby client.
+//
+// Requests are retried and failed over to other lobby servers according to RetryPolicy, see SetRetryPolicy and SetServerURLs.
 type Client struct {
-	client    *http.Client
-	serverURL string
-	agent     string
+	client *http.Client
+	agent  string
+	family AddrFamily
+
+	mu      sync.Mutex
+	hostKey string
+	tokens  map[serverGameKey]string
+	servers []*lobbyServer
+	cur     int
+	retry   RetryPolicy
 }
 
 // NewClient will create new client for our server
@@ -26,8 +47,9 @@ func NewClient(serverURL string) *Client {
 // NewClientWith accepts URL and custom HTTP client to use.
 func NewClientWith(serverURL string, client *http.Client) *Client {
 	return &Client{
-		client:    client,
-		serverURL: serverURL,
+		client:  client,
+		servers: []*lobbyServer{{url: serverURL}},
+		retry:   DefaultRetryPolicy,
 	}
 }
 
@@ -37,31 +59,238 @@ func (c *Client) SetUserAgent(agent string) {
 	c.agent = agent
 }
 
+// SetAddrFamily sets a preferred address family for listed games.
+// For dual-stack games, the address of this family will be returned as Game.Address, see Game.PreferAddr.
+func (c *Client) SetAddrFamily(f AddrFamily) {
+	c.family = f
+}
+
+// preferAddrs applies address family preference to the list.
+func (c *Client) preferAddrs(list []GameInfo) {
+	if c.family == AddrAny {
+		return
+	}
+	for i := range list {
+		list[i].PreferAddr(c.family)
+	}
+}
+
+// SetHostKey sets a host key issued by the lobby administrator. It will be used as a registration token
+// for games that weren't registered by this client yet. See TokenRegisterer.
+func (c *Client) SetHostKey(key string) {
+	c.mu.Lock()
+	c.hostKey = key
+	c.mu.Unlock()
+}
+
+// serverGameKey identifies a game registration on a specific lobby server.
+// Each lobby server issues its own registration tokens.
+type serverGameKey struct {
+	server string
+	game   gameKey
+}
+
+// tokenFor returns a registration token for a given game on a given lobby server.
+func (c *Client) tokenFor(server string, key gameKey) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if token, ok := c.tokens[serverGameKey{server: server, game: key}]; ok {
+		return token
+	}
+	return c.hostKey
+}
+
+// setToken remembers a registration token for a given game on a given lobby server.
+func (c *Client) setToken(server string, key gameKey, token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := serverGameKey{server: server, game: key}
+	if token == "" {
+		delete(c.tokens, k)
+		return
+	}
+	if c.tokens == nil {
+		c.tokens = make(map[serverGameKey]string)
+	}
+	c.tokens[k] = token
+}
+
 // ListGames implements Lobby.
 func (c *Client) ListGames(ctx context.Context) ([]GameInfo, error) {
 	var out ServerListResp
 	err := c.sendRequest(ctx, http.MethodGet, "/api/v0/games/list", nil, &out)
+	c.preferAddrs(out)
 	return out, err
 }
 
+// ListGamesWith is similar to ListGames, but allows filtering the list on the server side.
+// If a limit is set in options, it also returns a cursor for the next page, or an empty string for the last page.
+func (c *Client) ListGamesWith(ctx context.Context, opts *ListOptions) ([]GameInfo, string, error) {
+	path := "/api/v0/games/list"
+	if q := opts.Values(); len(q) != 0 {
+		path += "?" + q.Encode()
+	}
+	var out ServerListResp
+	resp := Response{Result: &out}
+	err := c.doRequest(ctx, http.MethodGet, path, "", nil, &resp)
+	c.preferAddrs(out)
+	return out, resp.Next, err
+}
+
+// ListGamesSources implements SourceLister. Sources are only reported by lobbies that aggregate multiple sources.
+func (c *Client) ListGamesSources(ctx context.Context) ([]GameInfo, []SourceStatus, error) {
+	var out ServerListResp
+	resp := Response{Result: &out}
+	err := c.doRequest(ctx, http.MethodGet, "/api/v0/games/list", "", nil, &resp)
+	c.preferAddrs(out)
+	return out, resp.Sources, err
+}
+
 // RegisterGame implements Lobby.
+//
+// The client remembers registration tokens returned by each lobby server and sends them automatically
+// with the following registrations of the same game on the same server.
 func (c *Client) RegisterGame(ctx context.Context, s *Game) error {
-	if err := c.sendRequest(ctx, http.MethodPost, "/api/v0/games/register", s, nil); err != nil {
+	key := s.gameKey()
+	token, server, err := c.registerGame(ctx, s, func(server string) string {
+		return c.tokenFor(server, key)
+	})
+	if err != nil {
+		return err
+	}
+	c.setToken(server, key, token)
+	return nil
+}
+
+// RegisterGameWithToken implements TokenRegisterer.
+//
+// The game is validated before sending, see Game.Validate. It is normalized by the server.
+func (c *Client) RegisterGameWithToken(ctx context.Context, s *Game, token string) (string, error) {
+	token, _, err := c.registerGame(ctx, s, func(string) string { return token })
+	return token, err
+}
+
+// registerGame registers the game with a token returned by auth for the selected lobby server.
+// It returns the new token and the URL of the server that accepted the registration.
+func (c *Client) registerGame(ctx context.Context, s *Game, auth func(server string) string) (string, string, error) {
+	if err := s.Validate(); err != nil {
+		return "", "", err
+	}
+	var out RegisterResp
+	server, err := c.doRequestAuth(ctx, http.MethodPost, "/api/v0/games/register", auth, s, &Response{Result: &out})
+	if err != nil {
+		return "", "", err
+	}
+	return out.Token, server, nil
+}
+
+// FindPlayer implements PlayerFinder.
+func (c *Client) FindPlayer(ctx context.Context, name string) ([]GameInfo, error) {
+	var out ServerListResp
+	q := url.Values{"name": {name}}
+	err := c.sendRequest(ctx, http.MethodGet, "/api/v0/players/find?"+q.Encode(), nil, &out)
+	c.preferAddrs(out)
+	return out, err
+}
+
+// UnregisterGame implements Unregisterer.
+//
+// The address is only used by the lobby if it trusts addresses sent by the clients.
+// Otherwise, the client's own address is used.
+func (c *Client) UnregisterGame(ctx context.Context, addr string, port int) error {
+	key := Game{Address: addr, Port: port}.gameKey()
+	req := &UnregisterReq{Address: addr, Port: port}
+	server, err := c.doRequestAuth(ctx, http.MethodPost, "/api/v0/games/unregister", func(server string) string {
+		return c.tokenFor(server, key)
+	}, req, &Response{})
+	if err != nil {
 		return err
 	}
+	c.setToken(server, key, "")
 	return nil
 }
 
+// UnregisterGameWithToken implements TokenUnregisterer.
+func (c *Client) UnregisterGameWithToken(ctx context.Context, addr string, port int, token string) error {
+	req := &UnregisterReq{Address: addr, Port: port}
+	return c.doRequest(ctx, http.MethodPost, "/api/v0/games/unregister", token, req, &Response{})
+}
+
 func (c *Client) sendRequest(ctx context.Context, meth string, path string, body interface{}, dst interface{}) error {
-	var rbody io.Reader
+	return c.doRequest(ctx, meth, path, "", body, &Response{Result: dst})
+}
+
+// doRequest sends the request and decodes the response envelope into out.
+// If token is set, it is sent as a bearer token. Errors returned by the server are converted to APIError.
+//
+// Idempotent requests are retried on temporary errors, see RetryPolicy.
+func (c *Client) doRequest(ctx context.Context, meth string, path string, token string, body interface{}, out *Response) error {
+	_, err := c.doRequestAuth(ctx, meth, path, func(string) string { return token }, body, out)
+	return err
+}
+
+// doRequestAuth is similar to doRequest, but selects the bearer token for each attempt,
+// depending on the lobby server URL. It returns the URL of the server that handled the request.
+func (c *Client) doRequestAuth(ctx context.Context, meth string, path string, auth func(server string) string, body interface{}, out *Response) (string, error) {
+	var data []byte
 	if body != nil {
-		data, err := json.Marshal(body)
+		var err error
+		data, err = json.Marshal(body)
 		if err != nil {
-			return err
+			return "", err
+		}
+	}
+	p := c.retryPolicy()
+	attempts := 1
+	if p.MaxAttempts > 1 && isIdempotent(meth, path) {
+		attempts = p.MaxAttempts
+	}
+	var last error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			wait := p.backoff(i)
+			var aerr *APIError
+			if errors.As(last, &aerr) && aerr.RetryAfter > 0 {
+				if aerr.RetryAfter > p.MaxBackoff {
+					break
+				}
+				wait = aerr.RetryAfter
+			}
+			if err := sleepCtx(ctx, wait); err != nil {
+				break
+			}
+		}
+		now := time.Now()
+		s := c.pickServer(now)
+		if s == nil {
+			if last == nil {
+				last = ErrCircuitOpen
+			}
+			continue
 		}
+		// fields of failed attempts must not leak into the following ones
+		resp := Response{Result: out.Result}
+		err := c.sendOnce(ctx, s.url, meth, path, auth(s.url), data, &resp)
+		*out = resp
+		if ctx.Err() != nil {
+			return s.url, err
+		}
+		c.reportResult(s, err, now)
+		if !isTemporary(err) {
+			return s.url, err
+		}
+		last = err
+	}
+	return "", last
+}
+
+// sendOnce sends a single request to a given lobby server.
+func (c *Client) sendOnce(ctx context.Context, base string, meth string, path string, token string, data []byte, out *Response) error {
+	var rbody io.Reader
+	if data != nil {
 		rbody = bytes.NewReader(data)
 	}
-	req, err := http.NewRequestWithContext(ctx, meth, c.serverURL+path, rbody)
+	req, err := http.NewRequestWithContext(ctx, meth, base+path, rbody)
 	if err != nil {
 		return err
 	}
@@ -71,20 +300,95 @@ func (c *Client) sendRequest(ctx context.Context, meth string, path string, body
 	if rbody != nil {
 		req.Header.Add("Content-Type", "application/json")
 	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if isPeerRequest(ctx) {
+		req.Header.Set(PeerHeader, "1")
+	}
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	var out Response
-	out.Result = dst
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		if resp.StatusCode/100 != 2 {
+			return newAPIErrorFrom(resp, &Response{})
+		}
 		return err
 	}
-	if out.Err != "" {
-		return errors.New(out.Err)
-	} else if resp.StatusCode/100 != 2 {
-		return errors.New("status: " + resp.Status)
+	if out.Err != "" || resp.StatusCode/100 != 2 {
+		return newAPIErrorFrom(resp, out)
 	}
 	return nil
 }
+
+// WatchGames implements Watcher. It uses Server-Sent Events stream from the lobby server.
+//
+// The stream is not restarted automatically. Only the server selection is affected by RetryPolicy.
+func (c *Client) WatchGames(ctx context.Context) (<-chan GameEvent, error) {
+	now := time.Now()
+	s := c.pickServer(now)
+	if s == nil {
+		return nil, ErrCircuitOpen
+	}
+	events, err := c.watchGames(ctx, s.url)
+	if ctx.Err() == nil {
+		c.reportResult(s, err, now)
+	}
+	return events, err
+}
+
+func (c *Client) watchGames(ctx context.Context, base string) (<-chan GameEvent, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/api/v0/games/watch", nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.agent != "" {
+		req.Header.Set("User-Agent", c.agent)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var out Response
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return nil, newAPIErrorFrom(resp, &out)
+	}
+	out := make(chan GameEvent)
+	go func() {
+		defer close(out)
+		defer resp.Body.Close()
+		sc := bufio.NewScanner(resp.Body)
+		sc.Buffer(nil, 1024*1024)
+		var data []byte
+		for sc.Scan() {
+			line := sc.Bytes()
+			if len(line) != 0 {
+				if v := bytes.TrimPrefix(line, []byte("data:")); len(v) != len(line) {
+					data = append(data, bytes.TrimPrefix(v, []byte(" "))...)
+				}
+				continue
+			}
+			if len(data) == 0 {
+				continue
+			}
+			var ev GameEvent
+			err := json.Unmarshal(data, &ev)
+			data = data[:0]
+			if err != nil {
+				return
+			}
+			ev.Game.PreferAddr(c.family)
+			select {
+			case <-ctx.Done():
+				return
+			case out <- ev:
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/noxworld-dev/lobby"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "register",
+		Short: "keep an unmodified Nox game server registered on the lobby",
+	}
+	fGame := cmd.Flags().String("game-addr", "127.0.0.1:18590", "UDP address of the Nox game server")
+	fVers := cmd.Flags().String("game-vers", lobby.DefaultLegacyVersion, "game version to report to the lobby")
+	fLobby := cmd.Flags().StringSlice("lobby", []string{"http://127.0.0.1:8080"}, "URL of the lobby server; additional URLs are used for failover")
+	fHostKey := cmd.Flags().String("host-key", "", "host key issued by the lobby administrator")
+	fAltAddr := cmd.Flags().String("alt-addr", "", "public address of the host in the other IP family, for dual-stack hosts")
+	fXWIS := cmd.Flags().Bool("xwis", false, "ask the lobby to publish the game on XWIS (requires --host-key)")
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		h, err := lobby.NewUDPGameHost(*fGame, *fVers)
+		if err != nil {
+			return err
+		}
+		if *fAltAddr != "" || *fXWIS {
+			h = overrideHost{GameHost: h, altAddr: *fAltAddr, xwis: *fXWIS}
+		}
+		if len(*fLobby) == 0 {
+			return errors.New("lobby URL must be set")
+		}
+		c := lobby.NewClient((*fLobby)[0])
+		c.SetServerURLs(*fLobby...)
+		c.SetUserAgent("nox-lobby-register/1.0")
+		if *fHostKey != "" {
+			c.SetHostKey(*fHostKey)
+		}
+		log.Printf("registering game %s on %s", *fGame, strings.Join(*fLobby, ", "))
+		err = lobby.KeepRegisteredWith(ctx, h, &lobby.KeepRegisteredOptions{
+			Registerers: []lobby.Registerer{c},
+			MinBackoff:  5 * time.Second,
+			MaxBackoff:  lobby.DefaultTimeout / 3,
+			OnEvent: func(ev lobby.RegisterEvent) {
+				if ev.Err != nil {
+					log.Printf("registration %s (%d): %v", ev.Status, ev.Failures, ev.Err)
+				} else {
+					log.Printf("game %s", ev.Status)
+				}
+			},
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	Root.AddCommand(cmd)
+}
+
+// overrideHost sets an alternative address and XWIS publishing for games returned by GameHost.
+type overrideHost struct {
+	lobby.GameHost
+	altAddr string
+	xwis    bool
+}
+
+func (h overrideHost) GameInfo(ctx context.Context) (*lobby.Game, error) {
+	g, err := h.GameHost.GameInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if h.altAddr != "" {
+		g.AltAddress = h.altAddr
+	}
+	g.PublishXWIS = h.xwis
+	return g, nil
+}
@@ -2,9 +2,16 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/noxworld-dev/xwis"
@@ -25,9 +32,102 @@ func init() {
 	fXWIS := cmd.Flags().Bool("xwis", true, "list games from XWIS as well")
 	fXLogin := cmd.Flags().String("xlogin", "", "XWIS login to use")
 	fXPass := cmd.Flags().String("xpass", "", "XWIS password to use")
+	fXPublish := cmd.Flags().Bool("xwis-publish", false, "publish games that opt in (publish_xwis) to XWIS; requires --xwis-publish-ip")
+	fXPublishIP := cmd.Flags().StringSlice("xwis-publish-ip", nil, "public IP of the lobby; only games hosted on it are published to XWIS")
+	fXPublishMax := cmd.Flags().Int("xwis-publish-max", lobby.DefaultXWISPublishMax, "max number of games published to XWIS")
 	fXCache := cmd.Flags().Duration("xcache", lobby.DefaultTimeout/2, "XWIS cache duration")
+	fSrcTimeout := cmd.Flags().Duration("source-timeout", 10*time.Second, "timeout for listing games from XWIS and each peer")
+	fXStale := cmd.Flags().Duration("xcache-stale", lobby.DefaultCacheMaxStale, "max staleness of cached XWIS and peer lists served when they are unavailable")
+	fDB := cmd.Flags().String("db", "", "database file for persisting game registrations across restarts")
+	fTokens := cmd.Flags().Bool("tokens", false, "require registration tokens for updating game registrations")
+	fHostKeys := cmd.Flags().StringSlice("host-key", nil, "host keys which can be used as registration tokens (enables tokens)")
+	fProbe := cmd.Flags().Bool("probe", false, "probe registered games over UDP to check if they are reachable")
+	fProbeHide := cmd.Flags().Bool("probe-hide", false, "hide games that failed the reachability probe (experimental, the probe format is not verified yet)")
+	fProbeTimeout := cmd.Flags().Duration("probe-timeout", lobby.DefaultProbeTimeout, "timeout for probing games")
+	fProbeInterval := cmd.Flags().Duration("probe-interval", lobby.DefaultProbeInterval, "interval for probing registered games again")
+	fPeers := cmd.Flags().StringSlice("peer", nil, "peer lobby to list games from, as URL or name=URL")
+	fStats := cmd.Flags().Bool("stats", false, "record game history and serve statistics API")
+	fStatsKeep := cmd.Flags().Duration("stats-keep", lobby.DefaultHistoryRetention, "how long to keep game history")
+	fRateReg := cmd.Flags().Float64("rate-register", 0, "max rate of game registration requests per second from a single IP (0 disables the limit)")
+	fBurstReg := cmd.Flags().Int("burst-register", 10, "max burst of game registration requests from a single IP")
+	fRateList := cmd.Flags().Float64("rate-list", 0, "max rate of other API requests per second from a single IP (0 disables the limit)")
+	fBurstList := cmd.Flags().Int("burst-list", 20, "max burst of other API requests from a single IP")
+	fRateAgent := cmd.Flags().Bool("rate-by-agent", false, "apply rate limits separately for each User-Agent on the same IP")
+	fProxies := cmd.Flags().StringSlice("trusted-proxy", nil, "trusted reverse proxy networks (CIDR); client address is taken from forwarding headers for requests coming from them")
+	fProxyProto := cmd.Flags().Bool("proxy-protocol", false, "accept PROXY protocol header on connections (from trusted proxies only, if set)")
+	fTLSHost := cmd.Flags().String("tls-host", ":443", "host the HTTPS server will listen on, if TLS is enabled")
+	fTLSDomains := cmd.Flags().StringSlice("tls-domain", nil, "domain to get TLS certificate for automatically via ACME (Let's Encrypt)")
+	fTLSCache := cmd.Flags().String("tls-cache-dir", "tls-cache", "directory for caching automatic TLS certificates")
+	fTLSEmail := cmd.Flags().String("tls-email", "", "contact email for the ACME account")
+	fTLSCert := cmd.Flags().String("tls-cert", "", "TLS certificate file, instead of automatic certificates")
+	fTLSKey := cmd.Flags().String("tls-key", "", "TLS private key file, instead of automatic certificates")
+	fACMEURL := cmd.Flags().String("tls-acme-url", "", "ACME directory URL (Let's Encrypt by default)")
+	fACMECA := cmd.Flags().String("tls-acme-ca", "", "CA certificate file to trust for the ACME server (for testing with a local ACME server)")
+	fBans := cmd.Flags().String("bans", "", "ban list file; it is reloaded automatically when changed")
+	fAdminKey := cmd.Flags().String("admin-key", "", "bearer key for the admin API (disabled if not set)")
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		var lb lobby.Lobby = lobby.NewLobby()
+		svc := lobby.NewLobby()
+		var st *lobby.BoltStorage
+		if *fDB != "" {
+			var err error
+			st, err = lobby.OpenBoltStorage(*fDB)
+			if err != nil {
+				return err
+			}
+			defer st.Close()
+			svc, err = lobby.NewLobbyWithStorage(context.Background(), st)
+			if err != nil {
+				return err
+			}
+			// runs before closing the storage
+			defer svc.Flush(context.Background())
+		}
+		if *fTokens {
+			svc.SetTokenAuth(true)
+		}
+		for _, key := range *fHostKeys {
+			svc.AddHostKey(key)
+		}
+		if *fProbe {
+			if *fProbeHide {
+				log.Println("warning: hiding unreachable games is experimental")
+			}
+			svc.SetProber(lobby.NewUDPProber(*fProbeTimeout), *fProbeHide)
+			svc.SetProbeInterval(*fProbeInterval)
+		}
+		if *fBans != "" {
+			bans, err := lobby.OpenBanList(*fBans)
+			if err != nil {
+				return err
+			}
+			go func() {
+				if err := bans.Watch(context.Background(), 0); err != nil {
+					log.Println("bans:", err)
+				}
+			}()
+			svc.SetBanList(bans)
+		}
+		// named game sources, in addition to local games
+		var (
+			sources []lobby.Source
+			admin   []lobby.Peer // underlying listers for the admin API
+		)
+		var pub *lobby.XWISPublisher
+		if *fXPublish {
+			if len(*fXPublishIP) == 0 {
+				return fmt.Errorf("--xwis-publish requires --xwis-publish-ip")
+			}
+			pub = lobby.NewXWISPublisher("")
+			if err := pub.SetPublicAddrs(*fXPublishIP...); err != nil {
+				return err
+			}
+			pub.SetMaxGames(*fXPublishMax)
+			go func() {
+				if err := pub.Run(context.Background(), svc); err != nil {
+					log.Println("xwis publish:", err)
+				}
+			}()
+		}
 		if *fXWIS {
 			log.Println("logging in to XWIS")
 			c, err := xwis.NewClient(context.Background(), *fXLogin, *fXPass)
@@ -36,13 +136,70 @@ func init() {
 			}
 			defer c.Close()
 			var lx lobby.Lister = lobby.NewXWISWithClient(c)
+			if pub != nil {
+				lx = lobby.NewXWISWithPublisher(c, pub)
+			}
 			if *fXCache > 0 {
-				lx = lobby.Cache(lx, *fXCache)
+				lx = lobby.CacheWith(lx, lobby.CacheOptions{Name: "xwis", Expire: *fXCache, MaxStale: *fXStale})
 			}
-			lb = lobby.Overlay(lb, lx)
+			sources = append(sources, lobby.Source{Name: "xwis", Lister: lx, Timeout: *fSrcTimeout})
+			admin = append(admin, lobby.Peer{Name: "xwis", Lister: lx})
+		}
+		if len(*fPeers) != 0 {
+			peers, err := parsePeers(*fPeers, lobby.CacheOptions{Expire: *fXCache, MaxStale: *fXStale})
+			if err != nil {
+				return err
+			}
+			for _, p := range peers {
+				sources = append(sources, lobby.Source{Name: p.Name, Lister: lobby.Peers(p), Timeout: *fSrcTimeout})
+			}
+			admin = append(admin, peers...)
+		}
+		lb := lobby.Aggregate(svc, sources...)
+		proxies, err := lobby.ParseTrustedProxies(*fProxies)
+		if err != nil {
+			return err
 		}
 		lsrv := lobby.NewServer(lb)
-		// TODO: auto TLS with Let's Encrypt
+		lsrv.SetTrustedProxies(proxies)
+		lsrv.SetAdminKey(*fAdminKey)
+		lsrv.SetAdminService(svc)
+		for _, src := range admin {
+			lsrv.AddSource(src.Name, src.Lister)
+		}
+		lsrv.SetRateLimits(
+			lobby.RateLimit{Rate: *fRateReg, Burst: *fBurstReg},
+			lobby.RateLimit{Rate: *fRateList, Burst: *fBurstList},
+			*fRateAgent,
+		)
+		watch, ok := lb.(lobby.Watcher)
+		if !ok {
+			watch = lobby.PollWatcher(lb, 0)
+		}
+		lsrv.SetWatcher(watch)
+		if *fStats {
+			hist := lobby.NewHistory(*fStatsKeep)
+			if st != nil {
+				var err error
+				hist, err = lobby.NewHistoryWithStorage(context.Background(), st, *fStatsKeep)
+				if err != nil {
+					return err
+				}
+			}
+			go func() {
+				if err := hist.Run(context.Background(), watch, 0); err != nil {
+					log.Println("history:", err)
+				}
+			}()
+			lsrv.SetHistory(hist)
+		}
+		players := lobby.NewPlayerIndex()
+		go func() {
+			if err := players.Run(context.Background(), watch); err != nil {
+				log.Println("players:", err)
+			}
+		}()
+		lsrv.SetPlayerFinder(players)
 		srv := &http.Server{
 			Addr: *fHost,
 			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -50,6 +207,35 @@ func init() {
 				lsrv.ServeHTTP(w, r)
 			}),
 		}
+		var tsrv *http.Server
+		if len(*fTLSDomains) != 0 || *fTLSCert != "" || *fTLSKey != "" {
+			opts := &lobby.TLSOptions{
+				CertFile:     *fTLSCert,
+				KeyFile:      *fTLSKey,
+				Domains:      *fTLSDomains,
+				CacheDir:     *fTLSCache,
+				Email:        *fTLSEmail,
+				DirectoryURL: *fACMEURL,
+			}
+			if *fACMECA != "" {
+				cli, err := newACMEClient(*fACMECA)
+				if err != nil {
+					return err
+				}
+				opts.HTTPClient = cli
+			}
+			_, port, err := net.SplitHostPort(*fTLSHost)
+			if err != nil {
+				return err
+			}
+			conf, redirect, err := opts.TLSConfig(port)
+			if err != nil {
+				return err
+			}
+			// plain HTTP server will only redirect to HTTPS
+			tsrv = &http.Server{Addr: *fTLSHost, Handler: srv.Handler, TLSConfig: conf}
+			srv.Handler = redirect
+		}
 		log.Println("serving lobby on", srv.Addr)
 		if *fMonitor != "" {
 			http.Handle("/metrics", promhttp.Handler())
@@ -72,7 +258,80 @@ func init() {
 				}()
 			}
 		}
-		return srv.ListenAndServe()
+		listen := func(addr string) (net.Listener, error) {
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			if *fProxyProto {
+				ln = lobby.NewProxyListener(ln, proxies)
+			}
+			return ln, nil
+		}
+		ln, err := listen(srv.Addr)
+		if err != nil {
+			return err
+		}
+		if tsrv == nil {
+			return srv.Serve(ln)
+		}
+		tln, err := listen(tsrv.Addr)
+		if err != nil {
+			return err
+		}
+		log.Println("serving lobby with TLS on", tsrv.Addr)
+		errc := make(chan error, 2)
+		go func() {
+			errc <- srv.Serve(ln)
+		}()
+		go func() {
+			errc <- tsrv.ServeTLS(tln, "", "")
+		}()
+		return <-errc
 	}
 	Root.AddCommand(cmd)
 }
+
+// newACMEClient creates an HTTP client for ACME requests which trusts a given CA certificate.
+func newACMEClient(caFile string) (*http.Client, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %q", caFile)
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: tr}, nil
+}
+
+// parsePeers parses peer lobby definitions in "URL" or "name=URL" format.
+// Peers are cached with given options, unless the expiration is zero.
+func parsePeers(list []string, cache lobby.CacheOptions) ([]lobby.Peer, error) {
+	var out []lobby.Peer
+	for _, s := range list {
+		name, addr := "", s
+		if i := strings.Index(s, "="); i >= 0 {
+			name, addr = s[:i], s[i+1:]
+		}
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer %q: %w", s, err)
+		}
+		if name == "" {
+			name = u.Host
+		}
+		c := lobby.NewClient(strings.TrimSuffix(addr, "/"))
+		c.SetUserAgent("nox-lobby")
+		var l lobby.Lister = c
+		if cache.Expire > 0 {
+			opts := cache
+			opts.Name = name
+			l = lobby.CacheWith(l, opts)
+		}
+		out = append(out, lobby.Peer{Name: name, Lister: l})
+	}
+	return out, nil
+}
@@ -0,0 +1,152 @@
+package lobby
+
+import (
+	"net/netip"
+	"strings"
+)
+
+const (
+	// xwisMaxName is a max length of the game name on XWIS. Longer names are truncated.
+	xwisMaxName = 15
+	// xwisMaxMap is a max length of the map name on XWIS. Longer names are truncated.
+	xwisMaxMap = 9
+)
+
+// gameMerger builds a deduplicated game list from multiple sources.
+//
+// Games with the same address and port are considered the same, and the first one wins.
+//
+// Since XWIS does not report game ports, games listed on XWIS are instead matched with other games on the same IP,
+// if both the name and the map are the same (accounting for truncation on XWIS). Fields of such games are merged,
+// see mergeXWISGame.
+type gameMerger struct {
+	list  []GameInfo
+	byKey map[gameKey]int
+	byIP  map[netip.Addr][]int
+}
+
+func newGameMerger() *gameMerger {
+	return &gameMerger{
+		byKey: make(map[gameKey]int),
+		byIP:  make(map[netip.Addr][]int),
+	}
+}
+
+// Games returns the merged list.
+func (m *gameMerger) Games() []GameInfo {
+	return m.list
+}
+
+// Add a game to the list. It returns false if the game was merged with or replaced by an existing one.
+func (m *gameMerger) Add(g GameInfo) bool {
+	key := g.gameKey()
+	if i, ok := m.byKey[key]; ok {
+		if likelySameGame(&m.list[i], &g) {
+			m.merge(i, g)
+		}
+		return false
+	}
+	if i, ok := m.find(&g); ok {
+		m.merge(i, g)
+		return false
+	}
+	i := len(m.list)
+	m.list = append(m.list, g)
+	m.byKey[key] = i
+	m.indexIP(i)
+	return true
+}
+
+func (m *gameMerger) indexIP(i int) {
+	g := &m.list[i]
+	for _, s := range []string{g.Address, g.AltAddress} {
+		a, err := parseAddr(s)
+		if err != nil {
+			continue
+		}
+		if !containsIndex(m.byIP[a], i) {
+			m.byIP[a] = append(m.byIP[a], i)
+		}
+	}
+}
+
+func containsIndex(arr []int, i int) bool {
+	for _, v := range arr {
+		if v == i {
+			return true
+		}
+	}
+	return false
+}
+
+// find an existing game on the same IP which is likely the same as the given one.
+func (m *gameMerger) find(g *GameInfo) (int, bool) {
+	for _, s := range []string{g.Address, g.AltAddress} {
+		a, err := parseAddr(s)
+		if err != nil {
+			continue
+		}
+		for _, i := range m.byIP[a] {
+			if likelySameGame(&m.list[i], g) {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// merge the game into an existing one. Exactly one of them must be listed on XWIS.
+func (m *gameMerger) merge(i int, g GameInfo) {
+	cur := &m.list[i]
+	if cur.Source == SourceXWIS {
+		// prefer the registered game: it has the real port and more details
+		g, *cur = *cur, g
+		// port of the XWIS game is not real, so it should not shadow other games
+		delete(m.byKey, g.gameKey())
+		m.byKey[cur.gameKey()] = i
+		m.indexIP(i)
+	}
+	mergeXWISGame(cur, &g)
+}
+
+// likelySameGame checks if one of the games is listed on XWIS, and the other one has the same name and map.
+// The caller must check that games are on the same IP.
+func likelySameGame(a, b *GameInfo) bool {
+	if (a.Source == SourceXWIS) == (b.Source == SourceXWIS) {
+		return false
+	}
+	if a.Name == "" || b.Name == "" {
+		return false
+	}
+	return truncEqual(a.Name, b.Name, xwisMaxName) && truncEqual(a.Map, b.Map, xwisMaxMap)
+}
+
+// truncEqual compares strings case-insensitively, assuming that one of them might be truncated to n bytes.
+func truncEqual(a, b string, n int) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(a) == n {
+		b = b[:n]
+	}
+	return strings.EqualFold(a, b)
+}
+
+// mergeXWISGame fills fields of the game that are not set from the same game listed on XWIS.
+func mergeXWISGame(g, x *GameInfo) {
+	if g.Access == "" {
+		g.Access = x.Access
+	}
+	if g.Res.Width == 0 {
+		g.Res = x.Res
+	}
+	if g.Quest == nil && x.Quest != nil {
+		g.Quest = x.Quest.Clone()
+	}
+	if x.SeenAt.After(g.SeenAt) {
+		g.SeenAt = x.SeenAt
+	}
+	if g.Reach == "" {
+		g.Reach, g.RTTMillis = x.Reach, x.RTTMillis
+	}
+}
@@ -0,0 +1,84 @@
+package lobby
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncEqual(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		exp  bool
+	}{
+		{"test", "test", true},
+		{"Test", "tEST", true},
+		{"test", "test2", false},
+		{"A Very Long Game", "A Very Long Gam", true},
+		{"A Very Long Gam", "A Very Long Game", true},
+		{"A Very Long Ga", "A Very Long Game", false},
+		{"", "", true},
+	} {
+		require.Equal(t, c.exp, truncEqual(c.a, c.b, xwisMaxName), "%q vs %q", c.a, c.b)
+	}
+}
+
+func TestAggregateDedupXWIS(t *testing.T) {
+	ctx := context.Background()
+	local := NewLobby()
+	g1 := initServers[1]
+	g1.Name = "A Very Long Game"
+	g1.Port = 18600
+	registerServer(t, local, g1)
+
+	now := time.Now().UTC()
+	xwisGame := func(g Game) GameInfo {
+		g.Port = DefaultGamePort
+		if len(g.Name) > xwisMaxName {
+			g.Name = g.Name[:xwisMaxName]
+		}
+		g.Access = AccessPassword
+		g.Res = Resolution{Width: 1024, Height: 768}
+		return GameInfo{Game: g, SeenAt: now, Source: SourceXWIS}
+	}
+	x1 := xwisGame(g1)
+	// same host, but a different game
+	x2 := xwisGame(g1)
+	x2.Map = "other"
+	x3 := xwisGame(initServers[2])
+	x4 := xwisGame(initServers[3])
+	p4 := GameInfo{Game: initServers[3], Source: SourcePeer, Origin: "peer"}
+	p4.Port = 18700
+
+	l := Aggregate(local,
+		Source{Name: "xwis", Lister: listerFunc(func(ctx context.Context) ([]GameInfo, error) {
+			return []GameInfo{x1, x2, x3, x4}, nil
+		})},
+		Source{Name: "peer", Lister: listerFunc(func(ctx context.Context) ([]GameInfo, error) {
+			return []GameInfo{p4}, nil
+		})},
+	)
+	list, sources, err := l.(SourceLister).ListGamesSources(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []SourceStatus{
+		{Name: "opennox", State: SourceOK, Games: 1},
+		{Name: "xwis", State: SourceOK, Games: 3},
+		{Name: "peer", State: SourceOK, Games: 0},
+	}, sources)
+	for i := range list {
+		list[i].SeenAt = time.Time{}
+	}
+	exp1 := GameInfo{Game: g1, Source: SourceOpenNox}
+	exp1.Access = AccessPassword
+	exp1.Res = x1.Res
+	exp2 := x2
+	exp2.SeenAt = time.Time{}
+	exp3 := x3
+	exp3.SeenAt = time.Time{}
+	exp4 := p4
+	exp4.Access = AccessPassword
+	exp4.Res = x4.Res
+	require.Equal(t, []GameInfo{exp2, exp1, exp3, exp4}, list)
+}
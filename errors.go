@@ -0,0 +1,152 @@
+package lobby
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnauthorized is returned when the request requires authorization, for example by the admin key.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRateLimited is returned when the request is rejected by the rate limiter.
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrNotFound is returned when the requested entity does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrNotImplemented is returned when the requested feature is not supported or disabled on the lobby server.
+	ErrNotImplemented = errors.New("not implemented")
+)
+
+// ErrorCode is a stable machine-readable code of the lobby API error. See Response.Code.
+type ErrorCode string
+
+// Error codes returned in Response.Code.
+const (
+	CodeInvalidRequest   = ErrorCode("invalid_request")
+	CodeInvalidGame      = ErrorCode("invalid_game")
+	CodeInvalidToken     = ErrorCode("invalid_token")
+	CodeBanned           = ErrorCode("banned")
+	CodeUnauthorized     = ErrorCode("unauthorized")
+	CodeForbidden        = ErrorCode("forbidden")
+	CodeNotFound         = ErrorCode("not_found")
+	CodeMethodNotAllowed = ErrorCode("method_not_allowed")
+	CodeRateLimited      = ErrorCode("rate_limited")
+	CodeNotImplemented   = ErrorCode("not_implemented")
+	CodeInternal         = ErrorCode("internal")
+)
+
+// errorCodes maps sentinel errors to error codes and HTTP statuses.
+var errorCodes = []struct {
+	code   ErrorCode
+	err    error
+	status int
+}{
+	{CodeInvalidGame, ErrInvalidGame, http.StatusBadRequest},
+	{CodeInvalidToken, ErrInvalidToken, http.StatusForbidden},
+	{CodeBanned, ErrBanned, http.StatusForbidden},
+	{CodeUnauthorized, ErrUnauthorized, http.StatusUnauthorized},
+	{CodeNotFound, ErrNotFound, http.StatusNotFound},
+	{CodeRateLimited, ErrRateLimited, http.StatusTooManyRequests},
+	{CodeNotImplemented, ErrNotImplemented, http.StatusNotImplemented},
+}
+
+// errorCode returns an error code for the error. If the error doesn't match any known sentinel,
+// the code is selected based on HTTP status.
+func errorCode(status int, err error) ErrorCode {
+	if err != nil {
+		for _, c := range errorCodes {
+			if errors.Is(err, c.err) {
+				return c.code
+			}
+		}
+	}
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusNotImplemented:
+		return CodeNotImplemented
+	}
+	return CodeInternal
+}
+
+// errorStatus returns an HTTP status for the error, or def if the error doesn't match any known sentinel.
+func errorStatus(err error, def int) int {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.status
+		}
+	}
+	return def
+}
+
+// Err returns a sentinel error for the code, or nil if there's none.
+func (c ErrorCode) Err() error {
+	for _, e := range errorCodes {
+		if e.code == c {
+			return e.err
+		}
+	}
+	return nil
+}
+
+// APIError is an error returned by the lobby HTTP API.
+//
+// It matches corresponding sentinel errors with errors.Is (for example, ErrRateLimited),
+// and validation errors can be extracted with errors.As into ValidationError.
+type APIError struct {
+	// Status is an HTTP status code of the response.
+	Status int
+	// Code is an error code returned by the server. For older servers, it is derived from the status.
+	Code ErrorCode
+	// Message is an error message returned by the server.
+	Message string
+	// RetryAfter is a delay requested by the server with Retry-After header, if any.
+	RetryAfter time.Duration
+
+	err error
+}
+
+// newAPIError creates an error from the HTTP status and the response envelope.
+func newAPIError(status int, resp *Response) *APIError {
+	e := &APIError{Status: status, Code: resp.Code, Message: resp.Err}
+	if e.Code == "" {
+		e.Code = errorCode(status, nil)
+	}
+	if e.Message == "" {
+		e.Message = fmt.Sprintf("status: %d %s", status, http.StatusText(status))
+	}
+	e.err = e.Code.Err()
+	if e.Code == CodeInvalidGame && resp.Field != "" {
+		reason := strings.TrimPrefix(e.Message, (&ValidationError{Field: resp.Field}).Error())
+		e.err = &ValidationError{Field: resp.Field, Reason: reason}
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// newAPIErrorFrom creates an error from the HTTP response and the response envelope.
+func newAPIErrorFrom(r *http.Response, resp *Response) *APIError {
+	e := newAPIError(r.StatusCode, resp)
+	e.RetryAfter = parseRetryAfter(r.Header.Get("Retry-After"), time.Now())
+	return e
+}
+
+// Unwrap returns the underlying sentinel or typed error, if any.
+func (e *APIError) Unwrap() error {
+	return e.err
+}
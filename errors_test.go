@@ -0,0 +1,80 @@
+package lobby
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientErrors(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	l.SetTokenAuth(true)
+	l.SetBanList(NewBanList())
+	api := NewServer(l)
+	api.SetAdminKey("secret")
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	// validation errors are reconstructed by the client
+	g := server1.Clone()
+	g.Map = "bad map"
+	err := c.sendRequest(ctx, "POST", "/api/v0/games/register", g, nil)
+	require.ErrorIs(t, err, ErrInvalidGame)
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	require.Equal(t, &ValidationError{Field: "map", Reason: `invalid character ' '`}, verr)
+	var aerr *APIError
+	require.True(t, errors.As(err, &aerr))
+	require.Equal(t, http.StatusBadRequest, aerr.Status)
+	require.Equal(t, CodeInvalidGame, aerr.Code)
+
+	// registration tokens
+	_, err = c.RegisterGameWithToken(ctx, server1.Clone(), "")
+	require.NoError(t, err)
+	_, err = c.RegisterGameWithToken(ctx, server1.Clone(), "bad")
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// bans
+	_, err = l.BanList().AddBan(Ban{Kind: BanAddr, Value: "127.0.0.1"})
+	require.NoError(t, err)
+	err = c.RegisterGame(ctx, server1.Clone())
+	require.ErrorIs(t, err, ErrBanned)
+	require.NotErrorIs(t, err, ErrInvalidToken)
+
+	// admin API
+	err = c.doRequest(ctx, "GET", "/api/v0/admin/games", "bad", nil, &Response{})
+	require.ErrorIs(t, err, ErrUnauthorized)
+	err = c.doRequest(ctx, "DELETE", "/api/v0/admin/bans?id=none", "secret", nil, &Response{})
+	require.ErrorIs(t, err, ErrNotFound)
+	err = c.doRequest(ctx, "GET", "/api/v0/stats/maps", "", nil, &Response{})
+	require.ErrorIs(t, err, ErrNotImplemented)
+
+	// other errors only have a code
+	err = c.doRequest(ctx, "PUT", "/api/v0/address", "", nil, &Response{})
+	require.True(t, errors.As(err, &aerr))
+	require.Equal(t, CodeMethodNotAllowed, aerr.Code)
+	require.Nil(t, errors.Unwrap(err))
+
+	// rate limits
+	api.SetRateLimits(RateLimit{Rate: 0.1, Burst: 1}, RateLimit{Rate: 0.1, Burst: 1}, false)
+	_, err = c.ListGames(ctx)
+	require.NoError(t, err)
+	_, err = c.ListGames(ctx)
+	require.ErrorIs(t, err, ErrRateLimited)
+}
+
+func TestAPIErrorStatus(t *testing.T) {
+	// responses from older servers or proxies without an error code
+	err := newAPIError(http.StatusTooManyRequests, &Response{})
+	require.ErrorIs(t, err, ErrRateLimited)
+	require.Equal(t, "status: 429 Too Many Requests", err.Error())
+	err = newAPIError(http.StatusBadGateway, &Response{Err: "bad gateway"})
+	require.Equal(t, CodeInternal, err.Code)
+	require.Equal(t, "bad gateway", err.Error())
+}
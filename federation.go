@@ -0,0 +1,131 @@
+package lobby
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// PeerHeader is an HTTP header set on requests made by one lobby to another.
+// Lobbies do not include games from their own peers when responding to such requests.
+const PeerHeader = "X-Nox-Lobby-Peer"
+
+type peerCtxKey struct{}
+
+// withPeerRequest marks the context as belonging to a request from a peer lobby.
+func withPeerRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, peerCtxKey{}, true)
+}
+
+// isPeerRequest checks if the context belongs to a request from a peer lobby.
+func isPeerRequest(ctx context.Context) bool {
+	v, _ := ctx.Value(peerCtxKey{}).(bool)
+	return v
+}
+
+// Peer is a remote lobby used for federation.
+type Peer struct {
+	// Name of the peer. It is set as GameInfo.Origin for all games listed by this peer.
+	Name string
+	// Lister for games of the peer. Usually a Client (optionally wrapped with Cache).
+	Lister Lister
+}
+
+// Peers creates a Lister which merges games listed by peer lobbies.
+//
+// Each game is tagged with the name of the peer in GameInfo.Origin, and with SourcePeer in GameInfo.Source
+// (unless it's an XWIS game listed by the peer). To prevent loops, peers are asked to
+// list only their own games, and games that already have an origin set are skipped.
+//
+// Errors from individual peers are only logged. An error is returned only if all peers fail.
+func Peers(peers ...Peer) Lister {
+	return &peerLister{peers: peers}
+}
+
+// Federate creates a Lobby which lists games from the local Lobby and all the peers.
+// Local games take priority over games from peers. Registration happens only on the local Lobby.
+//
+// Each peer is reported as a separate source, see Aggregate.
+func Federate(local Lobby, peers ...Peer) Lobby {
+	sources := make([]Source, 0, len(peers))
+	for _, p := range peers {
+		sources = append(sources, Source{Name: p.Name, Lister: Peers(p)})
+	}
+	return Aggregate(local, sources...)
+}
+
+type peerLister struct {
+	peers []Peer
+}
+
+// ListGames implements Lister.
+func (l *peerLister) ListGames(ctx context.Context) ([]GameInfo, error) {
+	list, _, err := l.ListGamesStatus(ctx)
+	return list, err
+}
+
+var _ StatusLister = (*peerLister)(nil)
+
+// ListGamesStatus implements StatusLister. The status is stale if any of the peers is stale,
+// and the update time is set to the oldest one.
+func (l *peerLister) ListGamesStatus(ctx context.Context) ([]GameInfo, ListStatus, error) {
+	if isPeerRequest(ctx) || len(l.peers) == 0 {
+		// do not forward requests from other peers
+		return nil, ListStatus{}, nil
+	}
+	ctx = withPeerRequest(ctx)
+	lists := make([][]GameInfo, len(l.peers))
+	stats := make([]ListStatus, len(l.peers))
+	errs := make([]error, len(l.peers))
+	var wg sync.WaitGroup
+	for i, p := range l.peers {
+		i, p := i, p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lists[i], stats[i], errs[i] = listGamesStatus(ctx, p.Lister)
+		}()
+	}
+	wg.Wait()
+	var (
+		out    []GameInfo
+		st     ListStatus
+		failed int
+	)
+	byAddr := make(map[gameKey]struct{})
+	for i, p := range l.peers {
+		if err := errs[i]; err != nil {
+			log.Printf("peer %q: %v", p.Name, err)
+			failed++
+			continue
+		}
+		if ps := stats[i]; ps.Stale && !st.Stale {
+			st.Stale, st.Err = true, ps.Err
+		}
+		if t := stats[i].Updated; !t.IsZero() && (st.Updated.IsZero() || t.Before(st.Updated)) {
+			st.Updated = t
+		}
+		for _, g := range lists[i] {
+			if g.Origin != "" {
+				// game from a peer of a peer
+				continue
+			}
+			key := g.gameKey()
+			if _, ok := byAddr[key]; ok {
+				continue
+			}
+			byAddr[key] = struct{}{}
+			g.Origin = p.Name
+			if g.Source != SourceXWIS {
+				g.Source = SourcePeer
+			}
+			out = append(out, g)
+		}
+	}
+	if failed == len(l.peers) {
+		return nil, ListStatus{}, fmt.Errorf("all peers failed: %w", errs[0])
+	}
+	sortGameInfos(out)
+	return out, st, nil
+}
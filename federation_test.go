@@ -0,0 +1,81 @@
+package lobby
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFederation(t *testing.T) {
+	ctx := context.Background()
+
+	// handlers are set later, since lobbies must know each other's URLs
+	var hA, hB http.Handler
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hA.ServeHTTP(w, r)
+	}))
+	defer srvA.Close()
+	srvB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hB.ServeHTTP(w, r)
+	}))
+	defer srvB.Close()
+
+	localA, localB := NewLobby(), NewLobby()
+	// lobbies are peers of each other, which must not cause a loop
+	lA := Federate(localA, Peer{Name: "b", Lister: NewClient(srvB.URL)})
+	lB := Federate(localB, Peer{Name: "a", Lister: NewClient(srvA.URL)})
+	hA, hB = NewServer(lA), NewServer(lB)
+
+	registerServer(t, localA, initServers[0])
+	registerServer(t, localB, initServers[1])
+	// same game registered on both - local must win
+	registerServer(t, localA, initServers[2])
+	registerServer(t, localB, initServers[2])
+
+	origins := func(l Lister) map[string]string {
+		list, err := l.ListGames(ctx)
+		require.NoError(t, err)
+		out := make(map[string]string)
+		for _, g := range list {
+			out[g.Name] = g.Origin
+			if g.Origin != "" {
+				require.Equal(t, SourcePeer, g.Source)
+			} else {
+				require.Equal(t, SourceOpenNox, g.Source)
+			}
+		}
+		return out
+	}
+	require.Equal(t, map[string]string{
+		initServers[0].Name: "",
+		initServers[1].Name: "b",
+		initServers[2].Name: "",
+	}, origins(lA))
+	require.Equal(t, map[string]string{
+		initServers[0].Name: "a",
+		initServers[1].Name: "",
+		initServers[2].Name: "",
+	}, origins(lB))
+	// same via HTTP
+	require.Equal(t, map[string]string{
+		initServers[0].Name: "",
+		initServers[1].Name: "b",
+		initServers[2].Name: "",
+	}, origins(NewClient(srvA.URL)))
+}
+
+func TestFederationPeerDown(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	local := NewLobby()
+	registerServer(t, local, initServers[0])
+	l := Federate(local, Peer{Name: "down", Lister: NewClient(srv.URL)})
+	list, err := l.ListGames(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+}
@@ -0,0 +1,178 @@
+package lobby
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ListOptions controls filtering and pagination of the game list.
+// Zero value of each field disables the corresponding filter.
+type ListOptions struct {
+	Mode       GameMode   // only list games with this mode
+	Map        string     // only list games on this map (case-insensitive)
+	Access     GameAccess // only list games with this access
+	Vers       string     // only list games with this version
+	Name       string     // only list games with name containing this substring (case-insensitive)
+	HasPlayers bool       // only list games with at least one player
+	NotFull    bool       // only list games that have free player slots
+	Source     GameSource // only list games from this source
+
+	// Limit sets the max number of games to return. Zero means no limit.
+	Limit int
+	// Cursor is an opaque value returned with the previous page. Listing continues after it.
+	Cursor string
+}
+
+// IsZero checks if options has no filters or pagination set.
+func (o *ListOptions) IsZero() bool {
+	return o == nil || *o == ListOptions{}
+}
+
+func (o *ListOptions) match(g *GameInfo) bool {
+	if o.Mode != "" && g.Mode != o.Mode {
+		return false
+	}
+	if o.Map != "" && !strings.EqualFold(g.Map, o.Map) {
+		return false
+	}
+	if o.Access != "" && g.Access != o.Access {
+		return false
+	}
+	if o.Vers != "" && g.Vers != o.Vers {
+		return false
+	}
+	if o.Name != "" && !strings.Contains(strings.ToLower(g.Name), strings.ToLower(o.Name)) {
+		return false
+	}
+	if o.HasPlayers && g.Players.Cur <= 0 {
+		return false
+	}
+	if o.NotFull && g.Players.Cur >= g.Players.Max {
+		return false
+	}
+	if o.Source != "" && g.Source != o.Source {
+		return false
+	}
+	return true
+}
+
+// Values encodes options as URL query parameters.
+func (o *ListOptions) Values() url.Values {
+	q := make(url.Values)
+	if o == nil {
+		return q
+	}
+	setStr := func(k, v string) {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	setStr("mode", string(o.Mode))
+	setStr("map", o.Map)
+	setStr("access", string(o.Access))
+	setStr("vers", o.Vers)
+	setStr("name", o.Name)
+	setStr("source", string(o.Source))
+	if o.HasPlayers {
+		q.Set("has_players", "true")
+	}
+	if o.NotFull {
+		q.Set("not_full", "true")
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	setStr("cursor", o.Cursor)
+	return q
+}
+
+// ListOptionsFromValues decodes options from URL query parameters.
+func ListOptionsFromValues(q url.Values) (*ListOptions, error) {
+	o := &ListOptions{
+		Mode:   GameMode(q.Get("mode")),
+		Map:    q.Get("map"),
+		Access: GameAccess(q.Get("access")),
+		Vers:   q.Get("vers"),
+		Name:   q.Get("name"),
+		Source: GameSource(q.Get("source")),
+		Cursor: q.Get("cursor"),
+	}
+	var err error
+	if v := q.Get("has_players"); v != "" {
+		if o.HasPlayers, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.New("invalid has_players value")
+		}
+	}
+	if v := q.Get("not_full"); v != "" {
+		if o.NotFull, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.New("invalid not_full value")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if o.Limit, err = strconv.Atoi(v); err != nil || o.Limit < 0 {
+			return nil, errors.New("invalid limit value")
+		}
+	}
+	return o, nil
+}
+
+func encodeCursor(k gameKey) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(k.String()))
+}
+
+func decodeCursor(s string) (gameKey, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return gameKey{}, errors.New("invalid cursor")
+	}
+	host, sport, err := net.SplitHostPort(string(data))
+	if err != nil {
+		return gameKey{}, errors.New("invalid cursor")
+	}
+	port, err := strconv.Atoi(sport)
+	if err != nil {
+		return gameKey{}, errors.New("invalid cursor")
+	}
+	// games from external sources may have invalid addresses, keep them as zero values, same as gameKey does
+	addr, _ := parseAddr(host)
+	return gameKey{Addr: addr, Port: port}, nil
+}
+
+// FilterGames filters a sorted list of games according to the options.
+// It returns a cursor for the next page, or an empty string if it was the last page.
+// The list is modified in place.
+func FilterGames(list []GameInfo, opts *ListOptions) ([]GameInfo, string, error) {
+	if opts.IsZero() {
+		return list, "", nil
+	}
+	var (
+		after    gameKey
+		hasAfter bool
+	)
+	if opts.Cursor != "" {
+		k, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after, hasAfter = k, true
+	}
+	out := list[:0]
+	for i := range list {
+		g := &list[i]
+		if hasAfter && !gameKeyLess(after, g.gameKey()) {
+			continue
+		}
+		if !opts.match(g) {
+			continue
+		}
+		if opts.Limit > 0 && len(out) >= opts.Limit {
+			return out, encodeCursor(out[len(out)-1].gameKey()), nil
+		}
+		out = append(out, *g)
+	}
+	return out, "", nil
+}
@@ -0,0 +1,105 @@
+package lobby
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterGames(t *testing.T) {
+	list := []GameInfo{
+		{Game: Game{Name: "Arena 1", Address: "1.1.1.1", Map: "estate", Mode: ModeArena, Access: AccessOpen, Players: PlayersInfo{Cur: 2, Max: 4}}},
+		{Game: Game{Name: "CTF", Address: "2.2.2.2", Map: "bunker", Mode: ModeCTF, Access: AccessOpen, Players: PlayersInfo{Cur: 0, Max: 8}}, Source: SourceXWIS},
+		{Game: Game{Name: "arena 2", Address: "3.3.3.3", Map: "estate", Mode: ModeArena, Access: AccessPassword, Players: PlayersInfo{Cur: 4, Max: 4}}},
+		{Game: Game{Name: "Arena 3", Address: "4.4.4.4", Map: "Estate", Mode: ModeArena, Access: AccessOpen, Players: PlayersInfo{Cur: 1, Max: 4}}},
+	}
+	names := func(list []GameInfo) []string {
+		var out []string
+		for _, g := range list {
+			out = append(out, g.Name)
+		}
+		return out
+	}
+	filter := func(opts *ListOptions) ([]string, string) {
+		cp := append([]GameInfo{}, list...)
+		out, next, err := FilterGames(cp, opts)
+		require.NoError(t, err)
+		return names(out), next
+	}
+
+	got, next := filter(nil)
+	require.Equal(t, []string{"Arena 1", "CTF", "arena 2", "Arena 3"}, got)
+	require.Empty(t, next)
+
+	got, _ = filter(&ListOptions{Mode: ModeArena, Map: "ESTATE"})
+	require.Equal(t, []string{"Arena 1", "arena 2", "Arena 3"}, got)
+
+	got, _ = filter(&ListOptions{Access: AccessOpen, HasPlayers: true})
+	require.Equal(t, []string{"Arena 1", "Arena 3"}, got)
+
+	got, _ = filter(&ListOptions{Name: "ARENA", NotFull: true})
+	require.Equal(t, []string{"Arena 1", "Arena 3"}, got)
+
+	got, _ = filter(&ListOptions{Source: SourceXWIS})
+	require.Equal(t, []string{"CTF"}, got)
+
+	opts := &ListOptions{Mode: ModeArena, Limit: 2}
+	got, next = filter(opts)
+	require.Equal(t, []string{"Arena 1", "arena 2"}, got)
+	require.NotEmpty(t, next)
+	opts.Cursor = next
+	got, next = filter(opts)
+	require.Equal(t, []string{"Arena 3"}, got)
+	require.Empty(t, next)
+
+	_, _, err := FilterGames(list, &ListOptions{Cursor: "???"})
+	require.Error(t, err)
+}
+
+func TestListOptionsValues(t *testing.T) {
+	opts := &ListOptions{
+		Mode: ModeCTF, Map: "bunker", Access: AccessOpen, Vers: "v1", Name: "x",
+		HasPlayers: true, NotFull: true, Source: SourcePeer, Limit: 10, Cursor: "abc",
+	}
+	got, err := ListOptionsFromValues(opts.Values())
+	require.NoError(t, err)
+	require.Equal(t, opts, got)
+
+	_, err = ListOptionsFromValues(map[string][]string{"limit": {"-1"}})
+	require.Error(t, err)
+}
+
+func TestListGamesWithHTTP(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	for _, g := range initServers {
+		registerServer(t, l, g)
+	}
+	api := NewServer(l)
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	opts := &ListOptions{Limit: 4}
+	list, next, err := c.ListGamesWith(ctx, opts)
+	require.NoError(t, err)
+	require.Len(t, list, 4)
+	require.NotEmpty(t, next)
+
+	opts.Cursor = next
+	list, next, err = c.ListGamesWith(ctx, opts)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, initServers[4:], []Game{list[0].Game, list[1].Game})
+	require.Empty(t, next)
+
+	list, _, err = c.ListGamesWith(ctx, &ListOptions{Name: "test3"})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, "test3", list[0].Name)
+
+	_, _, err = c.ListGamesWith(ctx, &ListOptions{Cursor: "???"})
+	require.Error(t, err)
+}
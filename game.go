@@ -56,16 +56,22 @@ type GameHost interface {
 // Game is an information about the Nox game, as provided by the server hosting it.
 // See GameInfo for an information returned by the lobby server.
 type Game struct {
-	Name    string      `json:"name"`
-	Address string      `json:"addr,omitempty"`
-	Port    int         `json:"port,omitempty"`
-	Map     string      `json:"map"`
-	Mode    GameMode    `json:"mode"`
-	Access  GameAccess  `json:"access,omitempty"`
-	Vers    string      `json:"vers,omitempty"`
-	Res     Resolution  `json:"res,omitempty"`
-	Players PlayersInfo `json:"players"`
-	Quest   *QuestInfo  `json:"quest,omitempty"`
+	Name    string `json:"name"`
+	Address string `json:"addr,omitempty"`
+	// AltAddress is an optional address of the same host in the other address family (IPv6 for IPv4 Address and vice versa).
+	// It allows dual-stack hosts to advertise both addresses in a single game.
+	AltAddress string      `json:"addr_alt,omitempty"`
+	Port       int         `json:"port,omitempty"`
+	Map        string      `json:"map"`
+	Mode       GameMode    `json:"mode"`
+	Access     GameAccess  `json:"access,omitempty"`
+	Vers       string      `json:"vers,omitempty"`
+	Res        Resolution  `json:"res,omitempty"`
+	Players    PlayersInfo `json:"players"`
+	Quest      *QuestInfo  `json:"quest,omitempty"`
+	// PublishXWIS requests the lobby to publish the game on XWIS, if the lobby supports it. See XWISPublisher.
+	// The game must be registered with a host key issued by the lobby administrator, see Service.AddHostKey.
+	PublishXWIS bool `json:"publish_xwis,omitempty"`
 }
 
 func (g *Game) Clone() *Game {
@@ -1,12 +1,14 @@
 module github.com/noxworld-dev/lobby
 
-go 1.17
+go 1.18
 
 require (
 	github.com/noxworld-dev/xwis v0.0.0-20211004170833-846701d6228d
 	github.com/prometheus/client_golang v0.9.3
 	github.com/spf13/cobra v1.2.1
-	github.com/stretchr/testify v1.7.0
+	github.com/stretchr/testify v1.8.1
+	go.etcd.io/bbolt v1.3.7
+	golang.org/x/crypto v0.5.0
 )
 
 require (
@@ -20,7 +22,10 @@ require (
 	github.com/prometheus/common v0.4.0 // indirect
 	github.com/prometheus/procfs v0.0.0-20190507164030-5867b95ac084 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/net v0.5.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+	golang.org/x/text v0.6.0 // indirect
 	google.golang.org/protobuf v1.26.0 // indirect
 	gopkg.in/irc.v3 v3.1.4 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
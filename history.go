@@ -0,0 +1,414 @@
+package lobby
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistoryRetention is a default duration for keeping game history.
+	DefaultHistoryRetention = 30 * 24 * time.Hour
+	// DefaultSampleInterval is a default interval for sampling player counts.
+	DefaultSampleInterval = time.Minute
+)
+
+// Session is a record of a single game session, from the first time the game was seen until it expired.
+type Session struct {
+	Address     string     `json:"addr"`
+	Port        int        `json:"port"`
+	Name        string     `json:"name"`
+	Vers        string     `json:"vers,omitempty"`
+	Modes       []GameMode `json:"modes"`
+	Maps        []string   `json:"maps"`
+	FirstSeen   time.Time  `json:"first_seen"`
+	LastSeen    time.Time  `json:"last_seen"`
+	Players     int        `json:"players"`
+	PeakPlayers int        `json:"peak_players"`
+	// PlayerNames is a list of all players seen in the game, if the game reports them.
+	PlayerNames []string `json:"player_names,omitempty"`
+	// PlayerSeconds is a number of players integrated over the session time.
+	PlayerSeconds float64 `json:"player_seconds"`
+	// Active is set if the game is still online.
+	Active bool `json:"active,omitempty"`
+}
+
+func (s *Session) clone() *Session {
+	s2 := *s
+	s2.Modes = append([]GameMode{}, s.Modes...)
+	s2.Maps = append([]string{}, s.Maps...)
+	s2.PlayerNames = append([]string(nil), s.PlayerNames...)
+	return &s2
+}
+
+// update accumulates player time and applies fresh game info to the session.
+func (s *Session) update(g *Game, now time.Time) {
+	if dt := now.Sub(s.LastSeen); dt > 0 {
+		s.PlayerSeconds += float64(s.Players) * dt.Seconds()
+	}
+	s.LastSeen = now
+	if g == nil {
+		return
+	}
+	s.Name, s.Vers = g.Name, g.Vers
+	if n := len(s.Modes); n == 0 || s.Modes[n-1] != g.Mode {
+		s.Modes = append(s.Modes, g.Mode)
+	}
+	if n := len(s.Maps); n == 0 || s.Maps[n-1] != g.Map {
+		s.Maps = append(s.Maps, g.Map)
+	}
+	s.Players = g.Players.Cur
+	if s.Players > s.PeakPlayers {
+		s.PeakPlayers = s.Players
+	}
+loop:
+	for _, p := range g.Players.List {
+		for _, name := range s.PlayerNames {
+			if name == p.Name {
+				continue loop
+			}
+		}
+		s.PlayerNames = append(s.PlayerNames, p.Name)
+	}
+}
+
+// PlayersSample is a sample of the total number of games and players at a given time.
+type PlayersSample struct {
+	Time    time.Time `json:"time"`
+	Games   int       `json:"games"`
+	Players int       `json:"players"`
+}
+
+// ServerStats is an aggregated statistics for a single game server.
+type ServerStats struct {
+	Address     string  `json:"addr"`
+	Port        int     `json:"port"`
+	Name        string  `json:"name"`
+	Sessions    int     `json:"sessions"`
+	OnlineHours float64 `json:"online_hours"`
+	PlayerHours float64 `json:"player_hours"`
+	PeakPlayers int     `json:"peak_players"`
+}
+
+// MapStats is an aggregated statistics for a single map.
+type MapStats struct {
+	Map         string `json:"map"`
+	Sessions    int    `json:"sessions"`
+	PeakPlayers int    `json:"peak_players"`
+}
+
+// HistoryStorage is a persistent storage for game history.
+type HistoryStorage interface {
+	// SaveSession saves a finished game session.
+	SaveSession(ctx context.Context, s *Session) error
+	// SaveSample saves a player count sample.
+	SaveSample(ctx context.Context, p *PlayersSample) error
+	// LoadHistory loads sessions that ended after a given time, and samples made after that time.
+	LoadHistory(ctx context.Context, since time.Time) ([]Session, []PlayersSample, error)
+	// PruneHistory removes sessions that ended before a given time, and samples made before that time.
+	PruneHistory(ctx context.Context, before time.Time) error
+}
+
+// NewHistory creates a new in-memory game history with a given retention.
+// If retention is zero, DefaultHistoryRetention is used.
+func NewHistory(retention time.Duration) *History {
+	if retention <= 0 {
+		retention = DefaultHistoryRetention
+	}
+	return &History{
+		retention: retention,
+		active:    make(map[gameKey]*Session),
+	}
+}
+
+// NewHistoryWithStorage creates a game history backed by a persistent storage.
+// Recent history is loaded from the storage.
+func NewHistoryWithStorage(ctx context.Context, st HistoryStorage, retention time.Duration) (*History, error) {
+	h := NewHistory(retention)
+	sessions, samples, err := st.LoadHistory(ctx, time.Now().Add(-h.retention))
+	if err != nil {
+		return nil, err
+	}
+	h.st = st
+	h.done = sessions
+	h.samples = samples
+	return h, nil
+}
+
+// History records game sessions and player counts. It is populated from game events, see Run.
+type History struct {
+	retention time.Duration
+	st        HistoryStorage
+
+	mu      sync.RWMutex
+	active  map[gameKey]*Session
+	done    []Session
+	samples []PlayersSample
+	// unseen is a set of active sessions not reported by the Watcher since it was subscribed, see resubscribed
+	unseen map[gameKey]struct{}
+}
+
+// Run consumes game events from the Watcher and samples the player count with a given interval,
+// until the context is canceled. If interval is zero, DefaultSampleInterval is used.
+func (h *History) Run(ctx context.Context, w Watcher, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultSampleInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	return runWatcher(ctx, "history", w, func(events <-chan GameEvent) {
+		h.resubscribed()
+		// initial events must be consumed before the first reconciliation
+		select {
+		case <-ticker.C:
+		default:
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-ticker.C:
+				h.reconcile()
+				h.Sample(t)
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				h.Observe(ev, time.Now())
+			}
+		}
+	})
+}
+
+// resubscribed is called when the Watcher is subscribed again. Initial events describe all current games,
+// thus active sessions which are not reported by them have ended in the meantime. See reconcile.
+func (h *History) resubscribed() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unseen = make(map[gameKey]struct{}, len(h.active))
+	for key := range h.active {
+		h.unseen[key] = struct{}{}
+	}
+}
+
+// reconcile closes active sessions which were not reported by the Watcher since it was subscribed again.
+func (h *History) reconcile() {
+	h.mu.Lock()
+	var ended []*Session
+	for key := range h.unseen {
+		s := h.active[key]
+		if s == nil {
+			continue
+		}
+		s.Active = false
+		delete(h.active, key)
+		// session ended at an unknown time, so it is not extended
+		i := sort.Search(len(h.done), func(i int) bool {
+			return h.done[i].LastSeen.After(s.LastSeen)
+		})
+		h.done = append(h.done, Session{})
+		copy(h.done[i+1:], h.done[i:])
+		h.done[i] = *s
+		ended = append(ended, s.clone())
+	}
+	h.unseen = nil
+	h.mu.Unlock()
+	for _, s := range ended {
+		h.saveSession(s)
+	}
+}
+
+// saveSession saves a finished session to the storage, if any. It must be called without the lock.
+func (h *History) saveSession(s *Session) {
+	if h.st == nil {
+		return
+	}
+	if err := h.st.SaveSession(context.Background(), s); err != nil {
+		log.Printf("cannot save game session: %v", err)
+	}
+}
+
+// Observe records a single game event that happened at a given time.
+func (h *History) Observe(ev GameEvent, now time.Time) {
+	if ended := h.observe(ev, now); ended != nil {
+		h.saveSession(ended)
+	}
+}
+
+// observe records the game event and returns the session if it has ended.
+func (h *History) observe(ev GameEvent, now time.Time) *Session {
+	key := ev.Game.gameKey()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.unseen, key)
+	s := h.active[key]
+	switch ev.Type {
+	case EventAdd, EventUpdate:
+		if s == nil {
+			s = &Session{
+				Address:   ev.Game.Address,
+				Port:      key.Port,
+				FirstSeen: now,
+				LastSeen:  now,
+				Active:    true,
+			}
+			h.active[key] = s
+		}
+		s.update(&ev.Game.Game, now)
+	case EventRemove:
+		if s == nil {
+			return nil
+		}
+		s.update(nil, now)
+		s.Active = false
+		delete(h.active, key)
+		h.done = append(h.done, *s)
+		return s.clone()
+	}
+	return nil
+}
+
+// Sample records the current number of games and players, and drops history older than retention.
+func (h *History) Sample(now time.Time) {
+	p, old := h.sample(now)
+	if h.st == nil {
+		return
+	}
+	if err := h.st.SaveSample(context.Background(), &p); err != nil {
+		log.Printf("cannot save players sample: %v", err)
+	}
+	if err := h.st.PruneHistory(context.Background(), old); err != nil {
+		log.Printf("cannot prune history: %v", err)
+	}
+}
+
+// sample records the sample in memory. It returns the sample and the time before which the history is dropped.
+func (h *History) sample(now time.Time) (PlayersSample, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p := PlayersSample{Time: now, Games: len(h.active)}
+	for _, s := range h.active {
+		s.update(nil, now)
+		p.Players += s.Players
+	}
+	h.samples = append(h.samples, p)
+	// both lists are sorted by time
+	old := now.Add(-h.retention)
+	i := sort.Search(len(h.samples), func(i int) bool {
+		return h.samples[i].Time.After(old)
+	})
+	h.samples = append(h.samples[:0], h.samples[i:]...)
+	i = sort.Search(len(h.done), func(i int) bool {
+		return h.done[i].LastSeen.After(old)
+	})
+	h.done = append(h.done[:0], h.done[i:]...)
+	return p, old
+}
+
+// Sessions returns all game sessions that were active after a given time. Active sessions are included.
+func (h *History) Sessions(since time.Time) []Session {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	now := time.Now()
+	var out []Session
+	for _, s := range h.done {
+		if s.LastSeen.After(since) {
+			out = append(out, *s.clone())
+		}
+	}
+	for _, s := range h.active {
+		s2 := s.clone()
+		s2.update(nil, now)
+		out = append(out, *s2)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].FirstSeen.Before(out[j].FirstSeen)
+	})
+	return out
+}
+
+// TopServers returns servers with the highest player time since a given time.
+// If limit is zero, all servers are returned.
+func (h *History) TopServers(since time.Time, limit int) []ServerStats {
+	byKey := make(map[gameKey]*ServerStats)
+	var out []*ServerStats
+	for _, s := range h.Sessions(since) {
+		key := Game{Address: s.Address, Port: s.Port}.gameKey()
+		st := byKey[key]
+		if st == nil {
+			st = &ServerStats{Address: s.Address, Port: s.Port}
+			byKey[key] = st
+			out = append(out, st)
+		}
+		st.Name = s.Name
+		st.Sessions++
+		st.OnlineHours += s.LastSeen.Sub(s.FirstSeen).Hours()
+		st.PlayerHours += s.PlayerSeconds / 3600
+		if s.PeakPlayers > st.PeakPlayers {
+			st.PeakPlayers = s.PeakPlayers
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.PlayerHours != b.PlayerHours {
+			return a.PlayerHours > b.PlayerHours
+		}
+		return a.OnlineHours > b.OnlineHours
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	list := make([]ServerStats, 0, len(out))
+	for _, st := range out {
+		list = append(list, *st)
+	}
+	return list
+}
+
+// PopularMaps returns maps that were played most often since a given time.
+// If limit is zero, all maps are returned.
+func (h *History) PopularMaps(since time.Time, limit int) []MapStats {
+	byMap := make(map[string]*MapStats)
+	var out []*MapStats
+	for _, s := range h.Sessions(since) {
+		for _, m := range s.Maps {
+			st := byMap[m]
+			if st == nil {
+				st = &MapStats{Map: m}
+				byMap[m] = st
+				out = append(out, st)
+			}
+			st.Sessions++
+			if s.PeakPlayers > st.PeakPlayers {
+				st.PeakPlayers = s.PeakPlayers
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Sessions != b.Sessions {
+			return a.Sessions > b.Sessions
+		}
+		return a.PeakPlayers > b.PeakPlayers
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	list := make([]MapStats, 0, len(out))
+	for _, st := range out {
+		list = append(list, *st)
+	}
+	return list
+}
+
+// PlayerSamples returns player count samples made after a given time.
+func (h *History) PlayerSamples(since time.Time) []PlayersSample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	i := sort.Search(len(h.samples), func(i int) bool {
+		return h.samples[i].Time.After(since)
+	})
+	return append([]PlayersSample{}, h.samples[i:]...)
+}
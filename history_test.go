@@ -0,0 +1,163 @@
+package lobby
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	at := func(min int) time.Time {
+		return start.Add(time.Duration(min) * time.Minute)
+	}
+	g1 := GameInfo{Game: initServers[0]}
+	g2 := GameInfo{Game: initServers[1]}
+
+	h := NewHistory(0)
+	g1.Players.Cur = 2
+	g1.Players.List = []PlayerInfo{{Name: "jack"}, {Name: "bob"}}
+	h.Observe(GameEvent{Type: EventAdd, Game: g1}, at(0))
+	h.Observe(GameEvent{Type: EventAdd, Game: g2}, at(0))
+	h.Sample(at(10))
+
+	g1.Map = "estate"
+	g1.Players.Cur = 4
+	g1.Players.List = []PlayerInfo{{Name: "jack"}, {Name: "alice"}}
+	h.Observe(GameEvent{Type: EventUpdate, Game: g1}, at(30))
+	h.Observe(GameEvent{Type: EventRemove, Game: g1}, at(60))
+	h.Observe(GameEvent{Type: EventRemove, Game: g2}, at(60))
+
+	sessions := h.Sessions(start)
+	require.Len(t, sessions, 2)
+	s := sessions[0]
+	require.Equal(t, g1.Name, s.Name)
+	require.Equal(t, []string{"testmap", "estate"}, s.Maps)
+	require.Equal(t, []GameMode{ModeArena}, s.Modes)
+	require.Equal(t, 4, s.PeakPlayers)
+	require.Equal(t, []string{"jack", "bob", "alice"}, s.PlayerNames)
+	require.InDelta(t, 2*30*60+4*30*60, s.PlayerSeconds, 0.001)
+	require.False(t, s.Active)
+
+	top := h.TopServers(start, 1)
+	require.Len(t, top, 1)
+	require.Equal(t, g1.Name, top[0].Name)
+	require.InDelta(t, 3.0, top[0].PlayerHours, 0.001)
+
+	maps := h.PopularMaps(start, 0)
+	require.Equal(t, []MapStats{
+		{Map: "testmap", Sessions: 2, PeakPlayers: 4},
+		{Map: "estate", Sessions: 1, PeakPlayers: 4},
+	}, maps)
+
+	samples := h.PlayerSamples(start)
+	require.Len(t, samples, 1)
+	require.Equal(t, PlayersSample{Time: at(10), Games: 2, Players: 2}, samples[0])
+}
+
+func TestHistoryStorage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "lobby.db")
+	st := newTestBoltStorage(t, path)
+	h, err := NewHistoryWithStorage(ctx, st, 0)
+	require.NoError(t, err)
+
+	now := time.Now()
+	h.Observe(GameEvent{Type: EventAdd, Game: GameInfo{Game: server1}}, now.Add(-time.Hour))
+	h.Sample(now.Add(-time.Minute))
+	h.Observe(GameEvent{Type: EventRemove, Game: GameInfo{Game: server1}}, now)
+	exp := h.Sessions(now.Add(-time.Hour))
+	require.NoError(t, st.Close())
+
+	st = newTestBoltStorage(t, path)
+	h, err = NewHistoryWithStorage(ctx, st, 0)
+	require.NoError(t, err)
+	got := h.Sessions(now.Add(-time.Hour))
+	require.Len(t, got, 1)
+	require.True(t, exp[0].LastSeen.Equal(got[0].LastSeen))
+	require.Equal(t, exp[0].Name, got[0].Name)
+	require.Len(t, h.PlayerSamples(now.Add(-time.Hour)), 1)
+}
+
+func TestHistoryHTTP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := NewLobby()
+	h := NewHistory(0)
+	go h.Run(ctx, l, testTimeout)
+	api := NewServer(l)
+	api.SetHistory(h)
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	registerServer(t, l, server1)
+	require.Eventually(t, func() bool {
+		var out []ServerStats
+		err := c.sendRequest(ctx, "GET", "/api/v0/stats/servers?since=1h", nil, &out)
+		return err == nil && len(out) == 1 && out[0].Name == server1.Name
+	}, time.Second, testTimeout)
+
+	var samples []PlayersSample
+	err := c.sendRequest(ctx, "GET", "/api/v0/stats/players", nil, &samples)
+	require.NoError(t, err)
+	require.NotEmpty(t, samples)
+
+	err = c.sendRequest(ctx, "GET", "/api/v0/stats/maps?since=bad", nil, nil)
+	require.Error(t, err)
+}
+
+// chanWatcher returns channels from subs on each WatchGames call.
+type chanWatcher struct {
+	subs chan chan GameEvent
+}
+
+func (w *chanWatcher) WatchGames(ctx context.Context) (<-chan GameEvent, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case ch := <-w.subs:
+		return ch, nil
+	}
+}
+
+func TestHistoryResubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &chanWatcher{subs: make(chan chan GameEvent)}
+	h := NewHistory(0)
+	go h.Run(ctx, w, testTimeout)
+
+	g1, g2 := GameInfo{Game: initServers[0]}, GameInfo{Game: initServers[1]}
+	ch := make(chan GameEvent, 2)
+	ch <- GameEvent{Type: EventAdd, Game: g1}
+	ch <- GameEvent{Type: EventAdd, Game: g2}
+	close(ch) // subscriber is dropped
+	w.subs <- ch
+
+	// the first game is removed while the history is not subscribed
+	ch = make(chan GameEvent, 1)
+	ch <- GameEvent{Type: EventAdd, Game: g2}
+	w.subs <- ch
+
+	active := func() map[string]bool {
+		out := make(map[string]bool)
+		for _, s := range h.Sessions(time.Now().Add(-time.Hour)) {
+			out[s.Name] = s.Active
+		}
+		return out
+	}
+	require.Eventually(t, func() bool {
+		a, ok := active()[g1.Name]
+		return ok && !a
+	}, 3*time.Second, testTimeout)
+	require.Equal(t, map[string]bool{g1.Name: false, g2.Name: true}, active())
+	require.Eventually(t, func() bool {
+		samples := h.PlayerSamples(time.Time{})
+		return len(samples) != 0 && samples[len(samples)-1].Games == 1
+	}, time.Second, testTimeout)
+}
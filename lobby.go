@@ -3,8 +3,11 @@ package lobby
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
+	"net/netip"
 	"sort"
-	"strings"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,8 +22,28 @@ const (
 type GameInfo struct {
 	Game
 	SeenAt time.Time `json:"seen_at,omitempty"`
+	// Reach is a reachability status of the game, if the lobby probes games. See Prober.
+	Reach ReachStatus `json:"reach,omitempty"`
+	// RTTMillis is a round-trip time to the game (in milliseconds) measured by the lobby when probing it.
+	RTTMillis int64 `json:"rtt_ms,omitempty"`
+	// Origin is the name of the peer lobby the game was listed on. It is empty for games listed by this lobby.
+	Origin string `json:"origin,omitempty"`
+	// Source of the game listing: OpenNox registration, XWIS or a peer lobby.
+	Source GameSource `json:"source,omitempty"`
 }
 
+// GameSource is a source of the game listing, see GameInfo.Source.
+type GameSource string
+
+const (
+	// SourceOpenNox is set for games registered via the lobby API.
+	SourceOpenNox = GameSource("opennox")
+	// SourceXWIS is set for games listed on XWIS. Such games are limited to features supported by the original Nox.
+	SourceXWIS = GameSource("xwis")
+	// SourcePeer is set for OpenNox games listed by peer lobbies, see GameInfo.Origin.
+	SourcePeer = GameSource("peer")
+)
+
 func (g *GameInfo) Clone() *GameInfo {
 	if g == nil {
 		return nil
@@ -38,6 +61,13 @@ type Registerer interface {
 	RegisterGame(ctx context.Context, s *Game) error
 }
 
+// Unregisterer is an interface for removing Nox games from lobby server.
+type Unregisterer interface {
+	// UnregisterGame removes the game registration immediately, without waiting for it to expire.
+	// If port is not set, DefaultGamePort is assumed. Removing a game that is not registered is not an error.
+	UnregisterGame(ctx context.Context, addr string, port int) error
+}
+
 // Lister is an interface for listing Nox games registered on lobby server.
 type Lister interface {
 	// ListGames returns a sorted list of games registered on this lobby.
@@ -57,67 +87,112 @@ type Lobby interface {
 // Once the channel triggers, GameHost.GameInfo is called to acquire fresh game info.
 //
 // The function returns when context is canceled, if an error is returned from GameHost.GameInfo,
-// or if lobby becomes unavailable.
+// or if lobby rejects the registration multiple times in a row. Temporary lobby failures (network errors,
+// server errors, rate limits) do not stop the registration.
+//
+// If Registerer implements Unregisterer, the game is unregistered when the context is canceled.
+//
+// See KeepRegisteredWith for more options.
 func KeepRegistered(ctx context.Context, l Registerer, update <-chan time.Time, h GameHost) error {
-	if update == nil {
-		ticker := time.NewTicker(DefaultTimeout / 3)
-		defer ticker.Stop()
-		update = ticker.C
-	}
-	failure := 0
-	for {
-		sctx, cancel := context.WithTimeout(ctx, DefaultTimeout/3)
-		info, err := h.GameInfo(sctx)
-		cancel()
-		if err != nil {
-			return err
-		}
-		err = l.RegisterGame(ctx, info)
-		if err != nil {
-			failure++
-			if failure > 3 {
-				return err
-			}
-		} else {
-			failure = 0
-		}
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-update:
-		}
+	err := KeepRegisteredWith(ctx, h, &KeepRegisteredOptions{
+		Registerers: []Registerer{l},
+		Update:      update,
+	})
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return nil
 	}
+	return err
 }
 
 func (g Game) gameKey() gameKey {
+	addr, _ := parseAddr(g.Address)
 	return gameKey{
-		Addr: g.Address,
+		Addr: addr,
 		Port: g.Port,
 	}
 }
 
 type gameKey struct {
-	Addr string
+	Addr netip.Addr
 	Port int
 }
 
-var _ Lobby = (*Service)(nil)
+func (k gameKey) String() string {
+	return net.JoinHostPort(k.Addr.String(), strconv.Itoa(k.Port))
+}
+
+var (
+	_ Lobby           = (*Service)(nil)
+	_ Watcher         = (*Service)(nil)
+	_ TokenRegisterer = (*Service)(nil)
+	_ Unregisterer    = (*Service)(nil)
+)
 
 // NewLobby creates a new in-memory Lobby.
 func NewLobby() *Service {
 	return &Service{
-		byAddr:  make(map[gameKey]*GameInfo),
-		timeout: DefaultTimeout,
+		byAddr:        make(map[gameKey]*GameInfo),
+		tokens:        make(map[gameKey]string),
+		probes:        make(map[gameKey]*probeState),
+		timeout:       DefaultTimeout,
+		probeInterval: DefaultProbeInterval,
 	}
 }
 
+// NewLobbyWithStorage creates a new Lobby backed by a persistent Storage.
+// Changes are written to the storage in background, see Flush.
+//
+// All games saved in the storage are loaded back. Expiration is checked relative to the time the games were
+// last seen, thus games that expire while the lobby is down are removed on the next garbage collection.
+func NewLobbyWithStorage(ctx context.Context, st Storage) (*Service, error) {
+	list, err := st.LoadGames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l := NewLobby()
+	l.store = newStoreQueue(st)
+	for i := range list {
+		g := &list[i].GameInfo
+		g.Source = SourceOpenNox
+		key := g.gameKey()
+		l.byAddr[key] = g
+		// games saved without a token are locked until they expire, see checkToken
+		l.tokens[key] = list[i].Token
+	}
+	return l, nil
+}
+
+// Flush writes pending changes to the Storage, if any. Changes are written in background, thus it should be
+// called before closing the storage.
+func (l *Service) Flush(ctx context.Context) error {
+	return l.store.flush(ctx)
+}
+
 // Service is an in-memory implementation of a Lobby.
+// It can optionally persist registrations to a Storage.
 type Service struct {
 	gc      int32 // atomic
 	mu      sync.RWMutex
 	byAddr  map[gameKey]*GameInfo
 	timeout time.Duration
 	lastGC  time.Time
+	store   *storeQueue // nil if there's no storage
+	events  broadcaster
+	// watchers is a number of active WatchGames calls; GC loop runs while there are any, see runGC
+	watchers int
+	stopGC   func()
+
+	tokenAuth bool
+	tokens    map[gameKey]string  // registration tokens, also restored from the storage
+	hostKeys  map[string]struct{} // admin-issued host keys
+
+	prober          Prober
+	probeInterval   time.Duration
+	probes          map[gameKey]*probeState
+	hideUnreachable bool
+	probeWorks      bool // at least one game responded to the probe
+
+	bans *BanList
 }
 
 // SetTimeout sets an expiration time for game registrations.
@@ -127,46 +202,130 @@ func (l *Service) SetTimeout(dt time.Duration) {
 	l.mu.Unlock()
 }
 
+// SetBanList sets a ban list for registered games. Banned games are removed and cannot be registered again.
+// Muted games are hidden from the list, but registration requests for them succeed.
+func (l *Service) SetBanList(b *BanList) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bans = b
+}
+
+// BanList returns a ban list set with SetBanList.
+func (l *Service) BanList() *BanList {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.bans
+}
+
 // RegisterGame implements Lobby.
 func (l *Service) RegisterGame(ctx context.Context, s *Game) error {
-	if s.Players.Cur < 0 {
-		return errors.New("players number should be positive")
-	}
-	if s.Players.Max <= 0 {
-		return errors.New("max players number should be set")
-	}
+	_, err := l.RegisterGameWithToken(ctx, s, "")
+	return err
+}
+
+// RegisterGameWithToken implements TokenRegisterer.
+func (l *Service) RegisterGameWithToken(ctx context.Context, s *Game, token string) (string, error) {
 	if s.Address == "" {
-		return errors.New("address must be set")
-	}
-	if s.Vers == "" {
-		return errors.New("version should be set")
-	}
-	if s.Map == "" {
-		return errors.New("map should be set")
+		return "", invalidField("addr", "must be set")
 	}
-	if s.Mode == "" {
-		return errors.New("mode should be set")
+	s.Normalize()
+	if err := s.Validate(); err != nil {
+		return "", err
 	}
-	if s.Name == "" || s.Name != strings.TrimSpace(s.Name) {
-		return errors.New("invalid server name")
+	if err := s.canonicalAddrs(); err != nil {
+		return "", err
 	}
 	if s.Port <= 0 {
 		s.Port = DefaultGamePort
 	}
-	s.Map = strings.ToLower(s.Map)
-	labels := serverLabels(sourceOpenNox, s)
-	cntGameSeen.WithLabelValues(labels...).Inc()
-	cntGamePlayers.WithLabelValues(labels...).Set(float64(s.Players.Cur))
-	info := &GameInfo{Game: *s}
+	info := &GameInfo{Game: *s, Source: SourceOpenNox}
 	key := s.gameKey()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	info.SeenAt = time.Now().UTC()
+	prev := l.byAddr[key]
+	if prev != nil && !l.isValid(prev, info.SeenAt) {
+		prev = nil
+	}
+	if ban := l.bans.Match(s); ban != nil {
+		// the token is not checked yet, so only remove the game if it is banned as well
+		if prev != nil && l.bans.Match(&prev.Game) != nil {
+			l.deleteGame(key, prev)
+		}
+		if ban.Mute {
+			return "", nil
+		}
+		return "", ErrBanned
+	}
+	token, err := l.checkToken(key, prev, token)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := l.hostKeys[token]; s.PublishXWIS && !ok {
+		return "", fmt.Errorf("%w: publishing games to XWIS requires a host key", ErrInvalidToken)
+	}
+	labels := serverLabels(sourceOpenNox, s)
+	cntGameSeen.WithLabelValues(labels...).Inc()
+	cntGamePlayers.WithLabelValues(labels...).Set(float64(s.Players.Cur))
+	if prev != nil {
+		info.Reach, info.RTTMillis = prev.Reach, prev.RTTMillis
+	}
 	l.byAddr[key] = info
+	l.probeGame(key, info.SeenAt)
+	if token != "" {
+		l.tokens[key] = token
+	}
+	l.store.save(key, &StoredGame{GameInfo: *info.Clone(), Token: token})
+	if ev, ok := gameEvent(prev, info); ok {
+		l.events.publish(ev)
+	}
 	l.maybeGC(info.SeenAt)
+	return token, nil
+}
+
+// UnregisterGame implements Unregisterer.
+func (l *Service) UnregisterGame(ctx context.Context, addr string, port int) error {
+	return l.UnregisterGameWithToken(ctx, addr, port, "")
+}
+
+// UnregisterGameWithToken implements TokenUnregisterer.
+func (l *Service) UnregisterGameWithToken(ctx context.Context, addr string, port int, token string) error {
+	if port <= 0 {
+		port = DefaultGamePort
+	}
+	ip, err := parseAddr(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+	key := gameKey{Addr: ip, Port: port}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	prev := l.byAddr[key]
+	if prev == nil {
+		return nil
+	}
+	if !l.isValid(prev, time.Now()) {
+		// will be removed by GC
+		return nil
+	}
+	if _, err := l.checkToken(key, prev, token); err != nil {
+		return err
+	}
+	l.deleteGame(key, prev)
 	return nil
 }
 
+// deleteGame removes the game registration. It must be called with the lock held.
+func (l *Service) deleteGame(key gameKey, prev *GameInfo) {
+	l.store.delete(key)
+	delete(l.byAddr, key)
+	delete(l.tokens, key)
+	delete(l.probes, key)
+	labels := serverLabels(sourceOpenNox, &prev.Game)
+	cntGamePlayers.WithLabelValues(labels...).Set(0)
+	l.events.publish(GameEvent{Type: EventRemove, Game: *prev})
+}
+
 func (l *Service) doGC() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -181,6 +340,10 @@ func (l *Service) maybeGC(now time.Time) {
 	for k, v := range l.byAddr {
 		if !l.isValid(v, now) {
 			delete(l.byAddr, k)
+			delete(l.tokens, k)
+			delete(l.probes, k)
+			l.events.publish(GameEvent{Type: EventRemove, Game: *v})
+			l.store.delete(k)
 			labels := serverLabels(sourceOpenNox, &v.Game)
 			cntGameExpired.WithLabelValues(labels...).Inc()
 			cntGamePlayers.WithLabelValues(labels...).Set(0)
@@ -188,6 +351,58 @@ func (l *Service) maybeGC(now time.Time) {
 	}
 }
 
+// WatchGames implements Watcher.
+func (l *Service) WatchGames(ctx context.Context) (<-chan GameEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	var initial []GameEvent
+	for _, v := range l.byAddr {
+		if l.isValid(v, now) {
+			if l.bans.Match(&v.Game) != nil {
+				continue
+			}
+			initial = append(initial, GameEvent{Type: EventAdd, Game: *v.Clone()})
+		}
+	}
+	sort.Slice(initial, func(i, j int) bool {
+		return gameKeyLess(initial[i].Game.gameKey(), initial[j].Game.gameKey())
+	})
+	// must subscribe while holding the lock to not miss any updates
+	ch := l.events.subscribe(ctx, initial)
+	l.watchers++
+	if l.watchers == 1 {
+		gctx, cancel := context.WithCancel(context.Background())
+		l.stopGC = cancel
+		go l.runGC(gctx)
+	}
+	go func() {
+		<-ctx.Done()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.watchers--
+		if l.watchers == 0 {
+			l.stopGC()
+			l.stopGC = nil
+		}
+	}()
+	return ch, nil
+}
+
+// runGC periodically removes expired games until the context is canceled.
+// GC is usually triggered by other requests, but watchers must receive expiration events even without them.
+func (l *Service) runGC(ctx context.Context) {
+	for {
+		l.mu.RLock()
+		timeout := l.timeout
+		l.mu.RUnlock()
+		if sleepCtx(ctx, timeout/2) != nil {
+			return
+		}
+		l.doGC()
+	}
+}
+
 func (l *Service) isValid(v *GameInfo, now time.Time) bool {
 	return v.SeenAt.Add(l.timeout).After(now)
 }
@@ -200,6 +415,12 @@ func (l *Service) listGames() ([]GameInfo, bool) {
 	gc := false
 	for _, v := range l.byAddr {
 		if l.isValid(v, now) {
+			if l.hideUnreachable && l.probeWorks && v.Reach == ReachFailed {
+				continue
+			}
+			if l.bans.Match(&v.Game) != nil {
+				continue
+			}
 			out = append(out, *v.Clone())
 		} else if !gc {
 			gc = atomic.CompareAndSwapInt32(&l.gc, 0, 1)
@@ -218,12 +439,15 @@ func (l *Service) ListGames(ctx context.Context) ([]GameInfo, error) {
 	return list, nil
 }
 
+func gameKeyLess(a, b gameKey) bool {
+	if c := a.Addr.Compare(b.Addr); c != 0 {
+		return c < 0
+	}
+	return a.Port < b.Port
+}
+
 func sortGameInfos(list []GameInfo) {
 	sort.Slice(list, func(i, j int) bool {
-		a, b := &list[i], &list[j]
-		if a.Address != b.Address {
-			return a.Address < b.Address
-		}
-		return a.Port < b.Port
+		return gameKeyLess(list[i].gameKey(), list[j].gameKey())
 	})
 }
@@ -48,6 +48,7 @@ var testList = []struct {
 }{
 	{name: "register", test: testLobbyRegister},
 	{name: "keep registered", test: testLobbyKeepRegistered},
+	{name: "unregister", test: testLobbyUnregister},
 	{name: "register concurrent", test: testLobbyRegisterConcurrent},
 	{name: "list concurrent", test: testLobbyListConcurrent},
 	{name: "mix concurrent", test: testLobbyMixConcurrent},
@@ -216,7 +217,39 @@ func testLobbyKeepRegistered(t testing.TB, l Lobby) {
 	}()
 	err := <-errc
 	require.NoError(t, err)
-	expectServers(t, l, []Game{server1})
+	if _, ok := l.(Unregisterer); ok {
+		// game must be removed when KeepRegistered returns
+		expectServers(t, l, nil)
+	} else {
+		expectServers(t, l, []Game{server1})
+	}
+}
+
+func testLobbyUnregister(t testing.TB, l Lobby) {
+	u, ok := l.(Unregisterer)
+	if !ok {
+		t.Skip("unregister is not supported")
+	}
+	ctx := context.Background()
+	for _, s := range initServers[:3] {
+		registerServer(t, l, s)
+	}
+	expectServers(t, l, initServers[:3])
+
+	err := u.UnregisterGame(ctx, initServers[1].Address, initServers[1].Port)
+	require.NoError(t, err)
+	expectServers(t, l, []Game{initServers[0], initServers[2]})
+
+	// unregister again and unregister a game that doesn't exist
+	err = u.UnregisterGame(ctx, initServers[1].Address, initServers[1].Port)
+	require.NoError(t, err)
+	err = u.UnregisterGame(ctx, initServers[4].Address, 0)
+	require.NoError(t, err)
+
+	// default port must be used when the port is not set
+	err = u.UnregisterGame(ctx, initServers[0].Address, 0)
+	require.NoError(t, err)
+	expectServers(t, l, initServers[2:3])
 }
 
 func registerServer(t testing.TB, testLobby Lobby, srv Game) {
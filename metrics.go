@@ -8,8 +8,8 @@ import (
 )
 
 const (
-	sourceOpenNox = "opennox"
-	sourceXWIS    = "xwis"
+	sourceOpenNox = string(SourceOpenNox)
+	sourceXWIS    = string(SourceXWIS)
 )
 
 var (
@@ -34,10 +34,26 @@ var (
 		Name: "nox_xwis_games",
 		Help: "Number of XWIS rooms",
 	})
+	cntXWISPublished = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nox_xwis_published",
+		Help: "Number of games published to XWIS",
+	})
 	cntRequests = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "nox_http_requests",
 		Help: "Number of HTTP requests to the API",
 	}, []string{"method", "endpoint", "agent"})
+	cntRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nox_http_rate_limited",
+		Help: "Number of HTTP requests to the API rejected by the rate limiter",
+	}, []string{"method", "endpoint"})
+	cntCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nox_cache_requests",
+		Help: "Number of game list requests served by the cache, by result (hit, miss, stale, error)",
+	}, []string{"cache", "result"})
+	cntSourceUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nox_source_up",
+		Help: "Whether the last game list request to the source succeeded",
+	}, []string{"source"})
 )
 
 func serverLabels(src string, g *Game) []string {
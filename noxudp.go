@@ -0,0 +1,178 @@
+package lobby
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/noxworld-dev/xwis"
+)
+
+// Nox game servers respond to UDP discovery requests with a short game info.
+//
+// Discovery request:
+//
+//	0x00 0x00 0x0C <token:u32>
+//
+// Server info response:
+//
+//	0x00 0x00 0x0D <token:u32> <game info>
+//
+// Token is an arbitrary value chosen by the client and echoed back by the server.
+//
+// Game info block uses the same binary layout as game info in XWIS room topics. The layout is implemented
+// by xwis.GameInfo, and is verified there against topics captured from XWIS (see TestNoxServerInfoFixture).
+//
+// The framing (the zero prefix, opcodes 0x0C/0x0D and the token) follows the message numbering of the Nox
+// network protocol as used by OpenNox, but it is NOT yet verified against a packet captured from a real server.
+// Thus, results of QueryGame should not be trusted for hiding games, see Service.SetProber.
+const (
+	noxMsgServerDiscover = 0x0C
+	noxMsgServerInfo     = 0x0D
+
+	noxMsgHeader      = 3
+	noxGameInfoMinLen = 69
+)
+
+var errNoxBadResponse = errors.New("invalid server info response")
+
+func encodeNoxDiscover(token uint32) []byte {
+	buf := make([]byte, noxMsgHeader+4)
+	buf[2] = noxMsgServerDiscover
+	binary.LittleEndian.PutUint32(buf[noxMsgHeader:], token)
+	return buf
+}
+
+func decodeNoxDiscover(data []byte) (uint32, bool) {
+	if len(data) < noxMsgHeader+4 || data[0] != 0 || data[1] != 0 || data[2] != noxMsgServerDiscover {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(data[noxMsgHeader:]), true
+}
+
+func encodeNoxServerInfo(token uint32, g *xwis.GameInfo) ([]byte, error) {
+	info, err := g.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, noxMsgHeader+4, noxMsgHeader+4+len(info))
+	buf[2] = noxMsgServerInfo
+	binary.LittleEndian.PutUint32(buf[noxMsgHeader:], token)
+	return append(buf, info...), nil
+}
+
+func decodeNoxServerInfo(data []byte, token uint32) (*xwis.GameInfo, error) {
+	if len(data) < noxMsgHeader+4+noxGameInfoMinLen || data[0] != 0 || data[1] != 0 || data[2] != noxMsgServerInfo {
+		return nil, errNoxBadResponse
+	}
+	if binary.LittleEndian.Uint32(data[noxMsgHeader:]) != token {
+		return nil, errNoxBadResponse
+	}
+	var g xwis.GameInfo
+	if err := g.UnmarshalBinary(data[noxMsgHeader+4:]); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// QueryGame queries a Nox game server directly over UDP.
+// It returns the game info and a round-trip time of the request.
+//
+// Address and port of the returned game are set to the ones used for the query.
+// If port is not set, DefaultGamePort is used.
+func QueryGame(ctx context.Context, addr string, port int) (*Game, time.Duration, error) {
+	if port <= 0 {
+		port = DefaultGamePort
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(addr, strconv.Itoa(port)))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	token := rand.Uint32()
+	start := time.Now()
+	if _, err = conn.Write(encodeNoxDiscover(token)); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, 0, ctx.Err()
+			}
+			return nil, 0, err
+		}
+		rtt := time.Since(start)
+		info, err := decodeNoxServerInfo(buf[:n], token)
+		if err == errNoxBadResponse {
+			continue // unrelated packet, or a late response to a different request
+		} else if err != nil {
+			return nil, 0, err
+		}
+		g := GameFromXWIS(info)
+		g.Address, g.Port = addr, port
+		return g, rtt, nil
+	}
+}
+
+// DefaultLegacyVersion is a game version reported for legacy Nox servers.
+const DefaultLegacyVersion = "1.2"
+
+// NewUDPGameHost creates a GameHost for an unmodified Nox game server, which queries game info over UDP.
+// See QueryGame.
+//
+// Address must be in host:port form; if port is not set, DefaultGamePort is used.
+// Version is reported to the lobby as-is; if not set, DefaultLegacyVersion is used.
+//
+// The address is used only for the queries. Returned games have no address set, thus the lobby will
+// use the address of the host registering the game.
+func NewUDPGameHost(addr string, vers string) (GameHost, error) {
+	host, port := addr, DefaultGamePort
+	if h, sport, err := net.SplitHostPort(addr); err == nil {
+		host = h
+		port, err = strconv.Atoi(sport)
+		if err != nil {
+			return nil, errors.New("invalid game port")
+		}
+	}
+	if vers == "" {
+		vers = DefaultLegacyVersion
+	}
+	return &udpGameHost{addr: host, port: port, vers: vers}, nil
+}
+
+type udpGameHost struct {
+	addr string
+	port int
+	vers string
+}
+
+// GameInfo implements GameHost.
+func (h *udpGameHost) GameInfo(ctx context.Context) (*Game, error) {
+	g, _, err := QueryGame(ctx, h.addr, h.port)
+	if err != nil {
+		return nil, err
+	}
+	g.Address = ""
+	g.Vers = h.vers
+	return g, nil
+}
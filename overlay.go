@@ -2,47 +2,239 @@ package lobby
 
 import (
 	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
 )
 
 // Overlay one lobby implementation over a second one.
 // Games from the overlay will override games from the base.
-// Registration will happen only on the overlay Lobby.
+// Registration and unregistration will happen only on the overlay Lobby.
+//
+// If the overlay has a ban list (see Service.SetBanList), it is applied to games from the base as well.
+//
+// It is equivalent to Aggregate with a single source named "base".
 func Overlay(over Lobby, base Lister) Lobby {
-	return &overlay{over: over, base: base}
+	return Aggregate(over, Source{Name: "base", Lister: base})
 }
 
-type overlay struct {
-	over Lobby
-	base Lister
+// Source is a named game source for Aggregate.
+type Source struct {
+	Name   string
+	Lister Lister
+	// Timeout for listing games from this source. Zero means no additional timeout.
+	Timeout time.Duration
 }
 
-func (l *overlay) RegisterGame(ctx context.Context, s *Game) error {
-	return l.over.RegisterGame(ctx, s)
+// SourceState is a state of a single game source, see SourceStatus.
+type SourceState string
+
+const (
+	// SourceOK is set when the source returned an up-to-date list.
+	SourceOK = SourceState("ok")
+	// SourceStale is set when the source failed, but a cached list was returned instead. See Cache.
+	SourceStale = SourceState("stale")
+	// SourceError is set when the source failed.
+	SourceError = SourceState("error")
+)
+
+// SourceStatus is a status of a single source of the aggregated game list.
+type SourceStatus struct {
+	Name  string      `json:"name"`
+	State SourceState `json:"state"`
+	Games int         `json:"games"`
+	// Age of the list in seconds, if the source is cached.
+	Age float64 `json:"age,omitempty"`
+	Err string  `json:"error,omitempty"`
 }
 
-func (l *overlay) ListGames(ctx context.Context) ([]GameInfo, error) {
-	list1, err1 := l.base.ListGames(ctx)
-	list2, err2 := l.over.ListGames(ctx)
-	if len(list1)+len(list2) == 0 {
-		if err2 != nil {
-			// overlay error takes priority
-			return nil, err2
-		}
-		// return base error
-		return nil, err1
+// SourceLister is implemented by Listers which aggregate multiple game sources, see Aggregate.
+type SourceLister interface {
+	// ListGamesSources is similar to Lister.ListGames, but also returns the status of each source.
+	// The error is only returned if all sources fail.
+	ListGamesSources(ctx context.Context) ([]GameInfo, []SourceStatus, error)
+}
+
+// Aggregate creates a Lobby which lists games from the local Lobby and multiple named sources concurrently.
+//
+// Local games take priority, followed by games from sources in the order they are passed.
+// Registration and unregistration will happen only on the local Lobby.
+// Local games are reported as the "opennox" source, and their GameInfo.Source is set to SourceOpenNox, if empty.
+//
+// Games with the same address and port are listed once. Since XWIS does not report game ports, games listed on XWIS
+// are also matched with other games on the same IP by name and map. Such games are merged: the registered game
+// is kept with its real port, and fields that are not set are filled from XWIS.
+//
+// Failed sources are skipped, and their status is reported by SourceLister. The error is returned only if
+// all sources fail, including the local one.
+//
+// If the local Lobby has a ban list (see Service.SetBanList), it is applied to games from all sources.
+func Aggregate(local Lobby, sources ...Source) Lobby {
+	return &aggregator{local: local, sources: sources, states: make(map[string]SourceState)}
+}
+
+type aggregator struct {
+	local   Lobby
+	sources []Source
+
+	mu     sync.Mutex
+	states map[string]SourceState // last logged state of each source, see logState
+}
+
+// logState logs the status of the source if its state changed since the last call.
+// Stale lists without errors are not logged, since they are only returned while the list is refreshed.
+func (l *aggregator) logState(st *SourceStatus) {
+	state := st.State
+	if state == SourceStale && st.Err == "" {
+		state = SourceOK
 	}
-	// if there are any results at all - suppress errors
-	byAddr := make(map[gameKey]GameInfo, len(list2))
-	for _, g := range list1 {
-		byAddr[g.gameKey()] = g
+	l.mu.Lock()
+	prev, ok := l.states[st.Name]
+	l.states[st.Name] = state
+	l.mu.Unlock()
+	if prev == state || (!ok && state == SourceOK) {
+		return
 	}
-	for _, g := range list2 {
-		byAddr[g.gameKey()] = g
+	switch state {
+	case SourceOK:
+		log.Printf("source %q: recovered", st.Name)
+	case SourceStale:
+		log.Printf("source %q: serving stale list: %s", st.Name, st.Err)
+	default:
+		log.Printf("source %q: %s", st.Name, st.Err)
+	}
+}
+
+var (
+	_ TokenRegisterer   = (*aggregator)(nil)
+	_ TokenUnregisterer = (*aggregator)(nil)
+	_ SourceLister      = (*aggregator)(nil)
+)
+
+// banLister is implemented by lobbies with a ban list.
+type banLister interface {
+	BanList() *BanList
+}
+
+// BanList returns a ban list of the local lobby, if any.
+func (l *aggregator) BanList() *BanList {
+	if b, ok := l.local.(banLister); ok {
+		return b.BanList()
+	}
+	return nil
+}
+
+func (l *aggregator) RegisterGame(ctx context.Context, s *Game) error {
+	return l.local.RegisterGame(ctx, s)
+}
+
+func (l *aggregator) RegisterGameWithToken(ctx context.Context, s *Game, token string) (string, error) {
+	if r, ok := l.local.(TokenRegisterer); ok {
+		return r.RegisterGameWithToken(ctx, s, token)
+	}
+	return "", l.local.RegisterGame(ctx, s)
+}
+
+func (l *aggregator) UnregisterGame(ctx context.Context, addr string, port int) error {
+	return l.UnregisterGameWithToken(ctx, addr, port, "")
+}
+
+func (l *aggregator) UnregisterGameWithToken(ctx context.Context, addr string, port int, token string) error {
+	switch u := l.local.(type) {
+	case TokenUnregisterer:
+		return u.UnregisterGameWithToken(ctx, addr, port, token)
+	case Unregisterer:
+		return u.UnregisterGame(ctx, addr, port)
+	}
+	return errors.New("unregistering games is not supported")
+}
+
+func (l *aggregator) ListGames(ctx context.Context) ([]GameInfo, error) {
+	list, _, err := l.ListGamesSources(ctx)
+	return list, err
+}
+
+// ListGamesSources implements SourceLister.
+func (l *aggregator) ListGamesSources(ctx context.Context) ([]GameInfo, []SourceStatus, error) {
+	sources := make([]Source, 0, 1+len(l.sources))
+	sources = append(sources, Source{Name: sourceOpenNox, Lister: l.local})
+	sources = append(sources, l.sources...)
+
+	type result struct {
+		list []GameInfo
+		st   ListStatus
+		err  error
+	}
+	results := make([]result, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		i, src := i, src
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sctx := ctx
+			if src.Timeout > 0 {
+				var cancel func()
+				sctx, cancel = context.WithTimeout(ctx, src.Timeout)
+				defer cancel()
+			}
+			r := &results[i]
+			r.list, r.st, r.err = listGamesStatus(sctx, src.Lister)
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	bans := l.BanList()
+	statuses := make([]SourceStatus, 0, len(sources))
+	games := newGameMerger()
+	failed := 0
+	for i, src := range sources {
+		r := results[i]
+		st := SourceStatus{Name: src.Name, State: SourceOK}
+		if !r.st.Updated.IsZero() {
+			st.Age = now.Sub(r.st.Updated).Round(time.Second).Seconds()
+		}
+		if r.err != nil {
+			st.State, st.Err = SourceError, r.err.Error()
+			cntSourceUp.WithLabelValues(src.Name).Set(0)
+			l.logState(&st)
+			statuses = append(statuses, st)
+			failed++
+			continue
+		}
+		cntSourceUp.WithLabelValues(src.Name).Set(1)
+		if r.st.Stale {
+			st.State = SourceStale
+			if r.st.Err != nil {
+				st.Err = r.st.Err.Error()
+			}
+		}
+		l.logState(&st)
+		list := r.list
+		if i != 0 {
+			list = bans.filterGames(list)
+		}
+		if i == 0 {
+			for j := range list {
+				if list[j].Source == "" {
+					list[j].Source = SourceOpenNox
+				}
+			}
+		}
+		for _, g := range list {
+			if games.Add(g) {
+				st.Games++
+			}
+		}
+		statuses = append(statuses, st)
 	}
-	list := make([]GameInfo, 0, len(byAddr))
-	for _, g := range byAddr {
-		list = append(list, g)
+	if failed == len(sources) {
+		// local error takes priority
+		return nil, statuses, results[0].err
 	}
-	sortGameInfos(list)
-	return list, nil
+	out := games.Games()
+	sortGameInfos(out)
+	return out, statuses, nil
 }
@@ -0,0 +1,159 @@
+package lobby
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// listerFunc implements Lister with a function.
+type listerFunc func(ctx context.Context) ([]GameInfo, error)
+
+func (f listerFunc) ListGames(ctx context.Context) ([]GameInfo, error) {
+	return f(ctx)
+}
+
+func TestAggregate(t *testing.T) {
+	ctx := context.Background()
+	local := NewLobby()
+	registerServer(t, local, initServers[2])
+	base := NewLobby()
+	registerServer(t, base, initServers[2])
+	registerServer(t, base, initServers[1])
+
+	errDown := errors.New("down")
+	sl := &slowLister{}
+	stale := CacheWith(sl, CacheOptions{Expire: time.Hour, MaxStale: time.Hour})
+	_, err := stale.ListGames(ctx)
+	require.NoError(t, err)
+	sl.set(nil, errDown)
+	lc := stale.(*listCache)
+	lc.mu.Lock()
+	lc.last = time.Now().Add(-90 * time.Minute)
+	lc.mu.Unlock()
+	// stale list starts a background refresh, which fails
+	_, err = stale.ListGames(ctx)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		lc.mu.Lock()
+		defer lc.mu.Unlock()
+		return lc.err != nil
+	}, time.Second, testTimeout/10)
+
+	l := Aggregate(local,
+		Source{Name: "base", Lister: base},
+		Source{Name: "down", Lister: listerFunc(func(ctx context.Context) ([]GameInfo, error) {
+			return nil, errDown
+		})},
+		Source{Name: "slow", Lister: listerFunc(func(ctx context.Context) ([]GameInfo, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}), Timeout: testTimeout},
+		Source{Name: "stale", Lister: stale},
+	)
+	expSources := []SourceStatus{
+		{Name: "opennox", State: SourceOK, Games: 1},
+		{Name: "base", State: SourceOK, Games: 1},
+		{Name: "down", State: SourceError, Err: "down"},
+		{Name: "slow", State: SourceError, Err: context.DeadlineExceeded.Error()},
+		{Name: "stale", State: SourceStale, Games: 1, Age: 5400, Err: "down"},
+	}
+	list, sources, err := l.(SourceLister).ListGamesSources(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+	require.Equal(t, expSources, sources)
+
+	// statuses are returned over HTTP
+	srv := httptest.NewServer(NewServer(l))
+	defer srv.Close()
+	list, sources, err = NewClient(srv.URL).ListGamesSources(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+	require.Equal(t, expSources, sources)
+
+	// error is only returned if all sources fail
+	l = Aggregate(listerLobby{listerFunc(func(ctx context.Context) ([]GameInfo, error) {
+		return nil, errDown
+	})}, Source{Name: "empty", Lister: NewLobby()})
+	list, err = l.ListGames(ctx)
+	require.NoError(t, err)
+	require.Empty(t, list)
+	l = Aggregate(listerLobby{listerFunc(func(ctx context.Context) ([]GameInfo, error) {
+		return nil, errDown
+	})})
+	_, err = l.ListGames(ctx)
+	require.ErrorIs(t, err, errDown)
+}
+
+// listerLobby is a Lobby that does not support registration.
+type listerLobby struct {
+	Lister
+}
+
+func (listerLobby) RegisterGame(ctx context.Context, s *Game) error {
+	return errors.New("not supported")
+}
+
+// logBuffer captures log output. Goroutines of other tests might still write to the log.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// count the number of occurrences of the substring, and reset the buffer.
+func (b *logBuffer) count(sub string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := strings.Count(b.buf.String(), sub)
+	b.buf.Reset()
+	return n
+}
+
+func TestAggregateLogState(t *testing.T) {
+	ctx := context.Background()
+	buf := new(logBuffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	var mu sync.Mutex
+	var errDown error
+	l := Aggregate(NewLobby(), Source{Name: "flaky", Lister: listerFunc(func(ctx context.Context) ([]GameInfo, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return nil, errDown
+	})})
+	setErr := func(err error) {
+		mu.Lock()
+		errDown = err
+		mu.Unlock()
+	}
+	logLines := func() int {
+		for i := 0; i < 3; i++ {
+			_, err := l.ListGames(ctx)
+			require.NoError(t, err)
+		}
+		return buf.count(`source "flaky"`)
+	}
+	require.Equal(t, 0, logLines())
+	setErr(errors.New("down"))
+	require.Equal(t, 1, logLines())
+	require.Equal(t, 0, logLines())
+	setErr(nil)
+	require.Equal(t, 1, logLines())
+	require.Equal(t, 0, logLines())
+}
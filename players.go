@@ -0,0 +1,111 @@
+package lobby
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// PlayerFinder is an interface for finding games a player is currently in.
+type PlayerFinder interface {
+	// FindPlayer returns a sorted list of games the player with a given name is currently in.
+	// Names are matched case-insensitively.
+	FindPlayer(ctx context.Context, name string) ([]GameInfo, error)
+}
+
+var _ PlayerFinder = (*PlayerIndex)(nil)
+
+// NewPlayerIndex creates a new empty PlayerIndex.
+func NewPlayerIndex() *PlayerIndex {
+	return &PlayerIndex{
+		games:  make(map[gameKey]GameInfo),
+		byName: make(map[string]map[gameKey]struct{}),
+	}
+}
+
+// PlayerIndex is an index of players in all listed games. It is populated from game events, see Run.
+//
+// Only games that report player names (see PlayersInfo.List) are indexed. Games from XWIS never report them.
+type PlayerIndex struct {
+	mu     sync.RWMutex
+	games  map[gameKey]GameInfo
+	byName map[string]map[gameKey]struct{}
+}
+
+func playerKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Run consumes game events from the Watcher until the context is canceled.
+func (p *PlayerIndex) Run(ctx context.Context, w Watcher) error {
+	return runWatcher(ctx, "players", w, func(events <-chan GameEvent) {
+		// initial events will describe all current games
+		p.reset()
+		for ev := range events {
+			p.Observe(ev)
+		}
+	})
+}
+
+func (p *PlayerIndex) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.games = make(map[gameKey]GameInfo)
+	p.byName = make(map[string]map[gameKey]struct{})
+}
+
+// removeGame must be called with the lock held.
+func (p *PlayerIndex) removeGame(key gameKey) {
+	g, ok := p.games[key]
+	if !ok {
+		return
+	}
+	delete(p.games, key)
+	for _, pl := range g.Players.List {
+		name := playerKey(pl.Name)
+		if m := p.byName[name]; m != nil {
+			delete(m, key)
+			if len(m) == 0 {
+				delete(p.byName, name)
+			}
+		}
+	}
+}
+
+// Observe records a single game event.
+func (p *PlayerIndex) Observe(ev GameEvent) {
+	key := ev.Game.gameKey()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeGame(key)
+	if ev.Type == EventRemove || len(ev.Game.Players.List) == 0 {
+		return
+	}
+	p.games[key] = *ev.Game.Clone()
+	for _, pl := range ev.Game.Players.List {
+		name := playerKey(pl.Name)
+		if name == "" {
+			continue
+		}
+		m := p.byName[name]
+		if m == nil {
+			m = make(map[gameKey]struct{})
+			p.byName[name] = m
+		}
+		m[key] = struct{}{}
+	}
+}
+
+// FindPlayer implements PlayerFinder.
+func (p *PlayerIndex) FindPlayer(ctx context.Context, name string) ([]GameInfo, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	m := p.byName[playerKey(name)]
+	out := make([]GameInfo, 0, len(m))
+	for key := range m {
+		g := p.games[key]
+		out = append(out, *g.Clone())
+	}
+	sortGameInfos(out)
+	return out, nil
+}
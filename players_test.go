@@ -0,0 +1,61 @@
+package lobby
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlayerIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := NewLobby()
+	l.SetTimeout(testTimeout * 3)
+	idx := NewPlayerIndex()
+	go idx.Run(ctx, l)
+
+	api := NewServer(l)
+	api.SetPlayerFinder(idx)
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	g1, g2 := initServers[0], initServers[1]
+	g1.Players.Cur, g1.Players.List = 2, []PlayerInfo{{Name: "Jack"}, {Name: "Bob"}}
+	g2.Players.Cur, g2.Players.List = 1, []PlayerInfo{{Name: "jack"}}
+	registerServer(t, l, g1)
+	registerServer(t, l, g2)
+
+	find := func(name string) []string {
+		list, err := c.FindPlayer(ctx, name)
+		require.NoError(t, err)
+		var out []string
+		for _, g := range list {
+			out = append(out, g.Name)
+		}
+		return out
+	}
+	require.Eventually(t, func() bool {
+		return len(find("JACK")) == 2
+	}, time.Second, testTimeout/3)
+	require.Equal(t, []string{g1.Name}, find("bob"))
+	require.Empty(t, find("alice"))
+
+	// player left the game
+	g1.Players.Cur, g1.Players.List = 1, []PlayerInfo{{Name: "Jack"}}
+	registerServer(t, l, g1)
+	require.Eventually(t, func() bool {
+		return len(find("bob")) == 0
+	}, time.Second, testTimeout/3)
+
+	// games expired
+	require.Eventually(t, func() bool {
+		return len(find("jack")) == 0
+	}, time.Second, testTimeout/3)
+
+	_, err := c.FindPlayer(ctx, "")
+	require.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package lobby
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ReachStatus is a reachability status of the game, as detected by the lobby.
+type ReachStatus string
+
+const (
+	// ReachUnknown means that the game was not probed (yet).
+	ReachUnknown = ReachStatus("")
+	// ReachOK means that the game responded to the probe.
+	ReachOK = ReachStatus("ok")
+	// ReachFailed means that the game did not respond to the probe.
+	ReachFailed = ReachStatus("unreachable")
+)
+
+const (
+	// DefaultProbeTimeout is a default timeout for probing games.
+	DefaultProbeTimeout = 3 * time.Second
+	// DefaultProbeInterval is a default interval for probing games again, while they keep registering.
+	DefaultProbeInterval = time.Minute
+	// probeAttempts is a number of probes sent before the game is considered unreachable.
+	probeAttempts = 3
+)
+
+// Prober checks if the game is reachable by players.
+type Prober interface {
+	// ProbeGame checks if the game is reachable and returns the round-trip time.
+	ProbeGame(ctx context.Context, addr string, port int) (time.Duration, error)
+}
+
+// NewUDPProber creates a Prober which sends Nox UDP discovery requests to the game. See QueryGame.
+// If timeout is zero, DefaultProbeTimeout is used.
+func NewUDPProber(timeout time.Duration) Prober {
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	return &udpProber{timeout: timeout}
+}
+
+type udpProber struct {
+	timeout time.Duration
+}
+
+// ProbeGame implements Prober.
+func (p *udpProber) ProbeGame(ctx context.Context, addr string, port int) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	_, rtt, err := QueryGame(ctx, addr, port)
+	return rtt, err
+}
+
+// SetProber sets a Prober for checking reachability of registered games.
+// Results are reported in GameInfo.Reach and GameInfo.RTTMillis.
+//
+// If hide is set, unreachable games are not listed. This is experimental: the UDP probe format is not verified
+// against real servers yet (see QueryGame), so games are only hidden after at least one game responded to the probe.
+// Otherwise, a wrong probe would hide every game in the lobby.
+//
+// Games are probed on the first registration, and then again on the following registrations,
+// if the last probe is older than the probe interval. See SetProbeInterval.
+func (l *Service) SetProber(p Prober, hide bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prober = p
+	l.hideUnreachable = hide
+}
+
+// SetProbeInterval sets an interval for probing registered games again. Default is DefaultProbeInterval.
+func (l *Service) SetProbeInterval(dt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.probeInterval = dt
+}
+
+// probeState tracks probes of a single game.
+type probeState struct {
+	last    time.Time // start of the last probe
+	running bool
+}
+
+// probeGame probes the game in the background and updates its reachability status.
+// The game is not probed if the previous probe is still running or is recent enough.
+// It must be called with the lock held.
+func (l *Service) probeGame(key gameKey, now time.Time) {
+	if l.prober == nil {
+		return
+	}
+	st := l.probes[key]
+	if st == nil {
+		st = new(probeState)
+		l.probes[key] = st
+	} else if st.running || now.Sub(st.last) < l.probeInterval {
+		return
+	}
+	st.last, st.running = now, true
+	p, timeout := l.prober, l.timeout
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		reach := ReachOK
+		var (
+			rtt time.Duration
+			err error
+		)
+		// a single lost packet should not make the game unreachable
+		for i := 0; i < probeAttempts; i++ {
+			rtt, err = p.ProbeGame(ctx, key.Addr.String(), key.Port)
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("game %s is unreachable: %v", key, err)
+			reach, rtt = ReachFailed, 0
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		st.running = false
+		if reach == ReachOK {
+			l.probeWorks = true
+		}
+		info := l.byAddr[key]
+		if info == nil {
+			return
+		}
+		info.Reach = reach
+		info.RTTMillis = rtt.Milliseconds()
+		l.store.save(key, &StoredGame{GameInfo: *info.Clone(), Token: l.tokens[key]})
+		l.events.publish(GameEvent{Type: EventUpdate, Game: *info.Clone()})
+	}()
+}
@@ -0,0 +1,249 @@
+package lobby
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestNoxServer starts a fake Nox game server which responds to UDP discovery requests.
+func newTestNoxServer(t testing.TB, g Game) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			token, ok := decodeNoxDiscover(buf[:n])
+			if !ok {
+				continue
+			}
+			resp, err := encodeNoxServerInfo(token, GameToXWIS(&g))
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// noxGameInfoFixture is a game info block captured from a real XWIS room topic of the "NoxCommunity EU" server.
+// It is taken from the decodedInfo fixture in github.com/noxworld-dev/xwis (crypt_test.go).
+const noxGameInfoFixture = "\x00\xff\x00\x00\x1d\xff\xff\xff\xff\x9eHheadache\x00NoxCommunity EU" +
+	"\xff\xff\xff\xff\xff\xef\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+	"\xff\xff\xff\xff\xff\xff\a!\x0f\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+
+// TestNoxServerInfoFixture decodes a server info response byte by byte, independently of encodeNoxServerInfo.
+//
+// Only the game info block comes from a real capture, the framing is assumed. See noxudp.go.
+func TestNoxServerInfoFixture(t *testing.T) {
+	const token = 0x04030201
+	require.Equal(t, []byte{0x00, 0x00, 0x0C, 0x01, 0x02, 0x03, 0x04}, encodeNoxDiscover(token))
+	tok, ok := decodeNoxDiscover([]byte{0x00, 0x00, 0x0C, 0x01, 0x02, 0x03, 0x04})
+	require.True(t, ok)
+	require.EqualValues(t, token, tok)
+
+	resp := append([]byte{0x00, 0x00, 0x0D, 0x01, 0x02, 0x03, 0x04}, noxGameInfoFixture...)
+	info, err := decodeNoxServerInfo(resp, token)
+	require.NoError(t, err)
+	g := GameFromXWIS(info)
+	require.Equal(t, "NoxCommunity EU", g.Name)
+	require.Equal(t, "headache", g.Map)
+	require.Equal(t, ModeArena, g.Mode)
+	require.Equal(t, AccessOpen, g.Access)
+	require.Equal(t, PlayersInfo{Cur: 0, Max: 29}, g.Players)
+
+	// token must match
+	_, err = decodeNoxServerInfo(resp, token+1)
+	require.ErrorIs(t, err, errNoxBadResponse)
+}
+
+func TestQueryGame(t *testing.T) {
+	g := Game{
+		Name:    "test",
+		Map:     "estate",
+		Mode:    ModeCTF,
+		Access:  AccessPassword,
+		Res:     Resolution{Width: 1024, Height: 768},
+		Players: PlayersInfo{Cur: 3, Max: 16},
+	}
+	port := newTestNoxServer(t, g)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	got, rtt, err := QueryGame(ctx, "127.0.0.1", port)
+	require.NoError(t, err)
+	require.True(t, rtt > 0)
+	g.Address, g.Port = "127.0.0.1", port
+	require.Equal(t, g, *got)
+}
+
+func TestServiceProbe(t *testing.T) {
+	ctx := context.Background()
+	g := server1
+	g.Address = "127.0.0.1"
+	g.Port = newTestNoxServer(t, g)
+
+	// a port with nothing listening on it
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	bad := g
+	bad.Name = "unreachable"
+	bad.Port = conn.LocalAddr().(*net.UDPAddr).Port
+	_ = conn.Close()
+
+	l := NewLobby()
+	l.SetProber(NewUDPProber(testTimeout*3), false)
+	require.NoError(t, l.RegisterGame(ctx, &g))
+	require.NoError(t, l.RegisterGame(ctx, &bad))
+
+	reach := func() map[string]ReachStatus {
+		list, err := l.ListGames(ctx)
+		require.NoError(t, err)
+		out := make(map[string]ReachStatus)
+		for _, g := range list {
+			out[g.Name] = g.Reach
+		}
+		return out
+	}
+	require.Eventually(t, func() bool {
+		m := reach()
+		return m[g.Name] != ReachUnknown && m[bad.Name] != ReachUnknown
+	}, time.Second, testTimeout/3)
+	require.Equal(t, map[string]ReachStatus{
+		g.Name:   ReachOK,
+		bad.Name: ReachFailed,
+	}, reach())
+
+	// status must be preserved on updates
+	require.NoError(t, l.RegisterGame(ctx, &g))
+	require.Equal(t, ReachOK, reach()[g.Name])
+
+	l.SetProber(NewUDPProber(testTimeout*3), true)
+	expectServers(t, l, []Game{g})
+}
+
+func TestServiceProbeHideUnverified(t *testing.T) {
+	l := NewLobby()
+	l.SetProber(proberFunc(func(ctx context.Context, addr string, port int) (time.Duration, error) {
+		return 0, errors.New("timeout")
+	}), true)
+	registerServer(t, l, server1)
+	require.Eventually(t, func() bool {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		info := l.byAddr[server1.gameKey()]
+		return info != nil && info.Reach == ReachFailed && !l.probes[server1.gameKey()].running
+	}, time.Second, testTimeout/10)
+	// no game ever responded, so the probe itself may be broken
+	expectServers(t, l, []Game{server1})
+}
+
+// proberFunc is a Prober implemented by a function.
+type proberFunc func(ctx context.Context, addr string, port int) (time.Duration, error)
+
+func (f proberFunc) ProbeGame(ctx context.Context, addr string, port int) (time.Duration, error) {
+	return f(ctx, addr, port)
+}
+
+func TestServiceProbeRetry(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls int
+		fails = probeAttempts - 1
+	)
+	l := NewLobby()
+	l.SetProbeInterval(testTimeout)
+	l.SetProber(proberFunc(func(ctx context.Context, addr string, port int) (time.Duration, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if fails > 0 {
+			fails--
+			return 0, errors.New("timeout")
+		}
+		return time.Millisecond, nil
+	}), true)
+	waitReach := func(exp ReachStatus) {
+		require.Eventually(t, func() bool {
+			l.mu.RLock()
+			defer l.mu.RUnlock()
+			info := l.byAddr[server1.gameKey()]
+			return info != nil && info.Reach == exp && !l.probes[server1.gameKey()].running
+		}, time.Second, testTimeout/10)
+	}
+
+	// lost probes are retried
+	registerServer(t, l, server1)
+	waitReach(ReachOK)
+	mu.Lock()
+	require.Equal(t, probeAttempts, calls)
+	fails = probeAttempts
+	mu.Unlock()
+
+	// recent probe is not repeated
+	registerServer(t, l, server1)
+	time.Sleep(testTimeout / 3)
+	mu.Lock()
+	require.Equal(t, probeAttempts, calls)
+	mu.Unlock()
+
+	// the game is probed again after the interval
+	time.Sleep(testTimeout)
+	registerServer(t, l, server1)
+	waitReach(ReachFailed)
+	expectServers(t, l, nil)
+
+	// and it becomes visible once it is reachable again
+	time.Sleep(testTimeout)
+	registerServer(t, l, server1)
+	waitReach(ReachOK)
+	expectServers(t, l, []Game{server1})
+}
+
+func TestUDPGameHost(t *testing.T) {
+	g := Game{
+		Name:    "legacy",
+		Map:     "estate",
+		Mode:    ModeArena,
+		Access:  AccessOpen,
+		Res:     Resolution{Width: 640, Height: 480},
+		Players: PlayersInfo{Cur: 1, Max: 8},
+	}
+	port := newTestNoxServer(t, g)
+	h, err := NewUDPGameHost(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), "")
+	require.NoError(t, err)
+
+	l := NewLobby()
+	srv := httptest.NewServer(NewServer(l))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- KeepRegistered(ctx, NewClient(srv.URL), nil, h)
+	}()
+	// address must be taken from the HTTP request, while port must match the game
+	g.Address, g.Port, g.Vers = "127.0.0.1", port, DefaultLegacyVersion
+	require.Eventually(t, func() bool {
+		list, err := l.ListGames(ctx)
+		return err == nil && len(list) == 1 && reflect.DeepEqual(list[0].Game, g)
+	}, time.Second, testTimeout/3)
+	cancel()
+	require.NoError(t, <-errc)
+}
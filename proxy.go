@@ -0,0 +1,259 @@
+package lobby
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout is a timeout for reading PROXY protocol header from a connection.
+const DefaultProxyHeaderTimeout = 10 * time.Second
+
+// TrustedProxies is a list of networks of trusted reverse proxies.
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses a list of trusted proxy networks in CIDR notation.
+// Plain IP addresses are accepted as well.
+func ParseTrustedProxies(list []string) (TrustedProxies, error) {
+	var out TrustedProxies
+	for _, s := range list {
+		n, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy address: %w", err)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// Contains checks if the IP belongs to one of the trusted proxies.
+func (p TrustedProxies) Contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP finds the client IP for a request that came from a trusted proxy with a given IP.
+//
+// Proxies append addresses to the end of the list, thus it is walked from the end, skipping trusted proxies.
+// If headers contain no usable addresses, the remote IP is returned.
+func (p TrustedProxies) clientIP(h http.Header, remote netip.Addr) netip.Addr {
+	var list []string
+	if v := h.Values("Forwarded"); len(v) != 0 {
+		list = parseForwarded(v)
+	} else if v := h.Values("X-Forwarded-For"); len(v) != 0 {
+		for _, s := range v {
+			list = append(list, strings.Split(s, ",")...)
+		}
+	} else if v := h.Get("X-Real-IP"); v != "" {
+		list = []string{v}
+	}
+	last := remote
+	for i := len(list) - 1; i >= 0; i-- {
+		ip, ok := parseForwardedIP(list[i])
+		if !ok {
+			// unknown or obfuscated address, cannot go further
+			break
+		}
+		last = ip
+		if !p.Contains(ip) {
+			break
+		}
+	}
+	return last
+}
+
+// parseForwarded returns values of "for" parameters from RFC 7239 Forwarded headers.
+func parseForwarded(headers []string) []string {
+	var out []string
+	for _, h := range headers {
+		for _, elem := range strings.Split(h, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				i := strings.Index(pair, "=")
+				if i < 0 {
+					continue
+				}
+				if !strings.EqualFold(strings.TrimSpace(pair[:i]), "for") {
+					continue
+				}
+				out = append(out, strings.Trim(strings.TrimSpace(pair[i+1:]), `"`))
+			}
+		}
+	}
+	return out
+}
+
+// parseForwardedIP parses a single address from forwarding headers. The address may contain a port.
+func parseForwardedIP(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	} else {
+		// IPv6 in brackets without a port
+		s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	}
+	ip, err := parseAddr(s)
+	return ip, err == nil
+}
+
+// NewProxyListener wraps the listener to accept connections with PROXY protocol header (v1 or v2).
+// The client address from the header will be returned by the RemoteAddr method of the connection.
+//
+// If trusted is set, only connections from these proxies are expected to send the header.
+// Otherwise, all connections must send it.
+func NewProxyListener(l net.Listener, trusted TrustedProxies) net.Listener {
+	return &proxyListener{Listener: l, trusted: trusted}
+}
+
+type proxyListener struct {
+	net.Listener
+	trusted TrustedProxies
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if len(l.trusted) != 0 {
+		if addr, ok := c.RemoteAddr().(*net.TCPAddr); !ok || !l.trusted.Contains(addr.AddrPort().Addr()) {
+			return c, nil
+		}
+	}
+	// header is read lazily, to not block the accept loop
+	return &proxyConn{Conn: c}, nil
+}
+
+type proxyConn struct {
+	net.Conn
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(DefaultProxyHeaderTimeout))
+		c.r = bufio.NewReader(c.Conn)
+		c.remote, c.err = readProxyHeader(c.r)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// readProxyHeader reads PROXY protocol header. It returns nil address if the header contains no address.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	if b, err := r.Peek(5); err == nil && string(b) == "PROXY" {
+		return readProxyHeaderV1(r)
+	}
+	b, err := r.Peek(len(proxyV2Sig))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read PROXY header: %w", err)
+	}
+	if !bytes.Equal(b, proxyV2Sig) {
+		return nil, errors.New("missing PROXY header")
+	}
+	return readProxyHeaderV2(r)
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	// max header size, according to the spec
+	const maxLen = 107
+	var line []byte
+	for len(line) < maxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read PROXY header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("invalid PROXY header")
+	}
+	f := strings.Fields(string(line))
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY header: %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(f[2])
+	port, err := strconv.ParseUint(f[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid PROXY header: %q", strings.TrimSpace(string(line)))
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("cannot read PROXY header: %w", err)
+	}
+	if vers := hdr[12] >> 4; vers != 2 {
+		return nil, fmt.Errorf("unsupported PROXY header version: %d", vers)
+	}
+	cmd, fam := hdr[12]&0xf, hdr[13]
+	data := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("cannot read PROXY header: %w", err)
+	}
+	const (
+		cmdLocal = 0x0
+		cmdProxy = 0x1
+
+		famTCP4 = 0x11
+		famTCP6 = 0x21
+	)
+	switch cmd {
+	case cmdLocal:
+		// health checks from the proxy itself
+		return nil, nil
+	case cmdProxy:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY command: %d", cmd)
+	}
+	switch {
+	case fam == famTCP4 && len(data) >= 12:
+		return &net.TCPAddr{IP: net.IP(data[0:4]), Port: int(binary.BigEndian.Uint16(data[8:]))}, nil
+	case fam == famTCP6 && len(data) >= 36:
+		return &net.TCPAddr{IP: net.IP(data[0:16]), Port: int(binary.BigEndian.Uint16(data[32:]))}, nil
+	}
+	// unsupported address family, use the connection address
+	return nil, nil
+}
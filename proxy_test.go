@@ -0,0 +1,147 @@
+package lobby
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/64"})
+	require.NoError(t, err)
+	_, err = ParseTrustedProxies([]string{"bad"})
+	require.Error(t, err)
+
+	api := NewServer(nil)
+	api.SetTrustedProxies(proxies)
+	for _, c := range []struct {
+		name   string
+		remote string
+		hdr    http.Header
+		exp    string
+	}{
+		{name: "direct", remote: "203.0.113.5:1234", exp: "203.0.113.5"},
+		{
+			name:   "untrusted",
+			remote: "203.0.113.5:1234",
+			hdr:    http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+			exp:    "203.0.113.5",
+		},
+		{
+			name:   "xff",
+			remote: "192.0.2.1:1234",
+			hdr:    http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1, 10.1.2.3"}},
+			exp:    "198.51.100.1",
+		},
+		{
+			name:   "xff multi",
+			remote: "192.0.2.1:1234",
+			hdr:    http.Header{"X-Forwarded-For": {"198.51.100.1", "10.1.2.3"}},
+			exp:    "198.51.100.1",
+		},
+		{
+			name:   "real ip",
+			remote: "10.0.0.1:1234",
+			hdr:    http.Header{"X-Real-Ip": {"198.51.100.1"}},
+			exp:    "198.51.100.1",
+		},
+		{
+			name:   "forwarded",
+			remote: "[2001:db8::1]:1234",
+			hdr:    http.Header{"Forwarded": {`for=1.2.3.4, for="[2001:db8:cafe::17]:4711";proto=http`}},
+			exp:    "2001:db8:cafe::17",
+		},
+		{
+			name:   "forwarded unknown",
+			remote: "10.0.0.1:1234",
+			hdr:    http.Header{"Forwarded": {"for=1.2.3.4, for=unknown"}},
+			exp:    "10.0.0.1",
+		},
+		{
+			name:   "all trusted",
+			remote: "10.0.0.1:1234",
+			hdr:    http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			exp:    "10.0.0.3",
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v0/address", nil)
+			req.RemoteAddr = c.remote
+			for k, v := range c.hdr {
+				req.Header[k] = v
+			}
+			ip, err := api.getAddress(req)
+			require.NoError(t, err)
+			require.Equal(t, c.exp, ip)
+		})
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: NewServer(nil)}
+	go srv.Serve(NewProxyListener(ln, nil))
+	defer srv.Close()
+
+	v2 := func(ip net.IP, port uint16) []byte {
+		b := append([]byte{}, proxyV2Sig...)
+		b = append(b, 0x21, 0x11, 0, 12)
+		b = append(b, ip.To4()...)
+		b = append(b, 127, 0, 0, 1)
+		var ports [4]byte
+		binary.BigEndian.PutUint16(ports[0:], port)
+		binary.BigEndian.PutUint16(ports[2:], 8080)
+		return append(b, ports[:]...)
+	}
+	for _, c := range []struct {
+		name string
+		hdr  []byte
+		exp  string
+	}{
+		{name: "v1", hdr: []byte("PROXY TCP4 198.51.100.1 127.0.0.1 5000 8080\r\n"), exp: "198.51.100.1"},
+		{name: "v1 v6", hdr: []byte("PROXY TCP6 2001:db8::1 ::1 5000 8080\r\n"), exp: "2001:db8::1"},
+		{name: "v1 unknown", hdr: []byte("PROXY UNKNOWN\r\n"), exp: "127.0.0.1"},
+		{name: "v2", hdr: v2(net.IPv4(198, 51, 100, 2), 5000), exp: "198.51.100.2"},
+		{name: "v2 local", hdr: append(append([]byte{}, proxyV2Sig...), 0x20, 0, 0, 0), exp: "127.0.0.1"},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = conn.Write(c.hdr)
+			require.NoError(t, err)
+			req, err := http.NewRequest(http.MethodGet, "http://lobby/api/v0/address", nil)
+			require.NoError(t, err)
+			require.NoError(t, req.Write(conn))
+			resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			var out struct {
+				Result IPResp `json:"data"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+			require.Equal(t, c.exp, out.Result.IP)
+		})
+	}
+
+	// no header
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	req, err := http.NewRequest(http.MethodGet, "http://lobby/api/v0/address", nil)
+	require.NoError(t, err)
+	require.NoError(t, req.Write(conn))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,297 @@
+package lobby
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/netip"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noxworld-dev/xwis"
+)
+
+// DefaultXWISPublishMax is a default max number of games published to XWIS at the same time.
+const DefaultXWISPublishMax = 16
+
+// NewXWISPublisher creates a publisher for XWIS server at a given address.
+// If address is empty, xwis.DefaultAddress is used.
+//
+// No games are published until public addresses of the lobby are set, see SetPublicAddrs.
+func NewXWISPublisher(addr string) *XWISPublisher {
+	if addr == "" {
+		addr = xwis.DefaultAddress
+	}
+	return &XWISPublisher{
+		addr:    addr,
+		retry:   RetryPolicy{MinBackoff: time.Second, MaxBackoff: time.Minute},
+		refresh: DefaultTimeout / 2,
+		max:     DefaultXWISPublishMax,
+		public:  make(map[netip.Addr]struct{}),
+		games:   make(map[gameKey]*xwisGame),
+		rooms:   make(map[string]struct{}),
+	}
+}
+
+// XWISPublisher publishes games registered on the lobby to XWIS, so that they are visible to the original Nox clients.
+// Only games which opt in with Game.PublishXWIS are published. It is populated from game events, see Run.
+//
+// Each game is published with a separate XWIS connection, which is closed when the game is removed or expires.
+//
+// XWIS lists the game with the IP address of the connection, and the original Nox clients always use the default
+// game port. Thus, only games hosted on the public address of the lobby with the default port are published,
+// see SetPublicAddrs. The number of published games is limited, see SetMaxGames.
+type XWISPublisher struct {
+	addr    string
+	retry   RetryPolicy
+	refresh time.Duration
+
+	mu     sync.Mutex
+	max    int
+	public map[netip.Addr]struct{}
+	games  map[gameKey]*xwisGame
+	rooms  map[string]struct{}
+}
+
+// SetPublicAddrs sets public IP addresses of the lobby, as seen by XWIS. Only games hosted on these addresses
+// are published. It does not affect games that are already published.
+func (p *XWISPublisher) SetPublicAddrs(addrs ...string) error {
+	public := make(map[netip.Addr]struct{}, len(addrs))
+	for _, s := range addrs {
+		ip, err := parseAddr(s)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %w", s, err)
+		}
+		public[ip] = struct{}{}
+	}
+	p.mu.Lock()
+	p.public = public
+	p.mu.Unlock()
+	return nil
+}
+
+// SetMaxGames sets a max number of games published at the same time. Default is DefaultXWISPublishMax.
+func (p *XWISPublisher) SetMaxGames(n int) {
+	p.mu.Lock()
+	p.max = n
+	p.mu.Unlock()
+}
+
+// canPublish checks if the game is reachable by the original Nox clients when published by the lobby.
+// It must be called with the lock held.
+func (p *XWISPublisher) canPublish(g *GameInfo) bool {
+	if g.Port != DefaultGamePort {
+		return false
+	}
+	for _, s := range []string{g.Address, g.AltAddress} {
+		if ip, err := parseAddr(s); err == nil {
+			if _, ok := p.public[ip]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// xwisGame is a single game published to XWIS.
+type xwisGame struct {
+	login  string
+	room   string
+	cancel func()
+	done   chan struct{}
+	update chan struct{}
+
+	mu   sync.Mutex
+	info xwis.GameInfo
+}
+
+func (g *xwisGame) setInfo(info *xwis.GameInfo) {
+	g.mu.Lock()
+	g.info = *info
+	g.mu.Unlock()
+	select {
+	case g.update <- struct{}{}:
+	default:
+	}
+}
+
+func (g *xwisGame) getInfo() xwis.GameInfo {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.info
+}
+
+// xwisRoom returns an XWIS room ID used for games hosted by a given login.
+func xwisRoom(login string) string {
+	return strings.ToLower(fmt.Sprintf("#%s's_game", login))
+}
+
+// Published checks if the XWIS room with a given ID is currently used by the publisher.
+// It allows skipping published games when listing XWIS, see NewXWISWithPublisher.
+func (p *XWISPublisher) Published(room string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.rooms[strings.ToLower(room)]
+	return ok
+}
+
+// loginFor returns a unique XWIS login for the game. It must be called with the lock held.
+func (p *XWISPublisher) loginFor(key gameKey) string {
+	for i := 0; ; i++ {
+		h := fnv.New32a()
+		fmt.Fprintf(h, "%s/%d", key, i)
+		// XWIS logins are limited to 9 characters
+		login := fmt.Sprintf("onx%06x", h.Sum32()&0xffffff)
+		if _, ok := p.rooms[xwisRoom(login)]; !ok {
+			return login
+		}
+	}
+}
+
+// Run consumes game events from the Watcher until the context is canceled. All games are removed from XWIS
+// when the function returns.
+func (p *XWISPublisher) Run(ctx context.Context, w Watcher) error {
+	defer p.reset()
+	return runWatcher(ctx, "xwis", w, func(events <-chan GameEvent) {
+		// initial events will describe all current games
+		p.reset()
+		for ev := range events {
+			p.Observe(ctx, ev)
+		}
+	})
+}
+
+// reset removes all games from XWIS and waits for connections to close.
+func (p *XWISPublisher) reset() {
+	p.mu.Lock()
+	games := p.games
+	p.games = make(map[gameKey]*xwisGame)
+	p.mu.Unlock()
+	for _, g := range games {
+		g.cancel()
+	}
+	for _, g := range games {
+		<-g.done
+	}
+}
+
+// Observe handles a single game event. Games are published asynchronously, until the context is canceled.
+func (p *XWISPublisher) Observe(ctx context.Context, ev GameEvent) {
+	key := ev.Game.gameKey()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g := p.games[key]
+	publish := ev.Type != EventRemove && ev.Game.PublishXWIS && (ev.Game.Source == "" || ev.Game.Source == SourceOpenNox)
+	if publish && !p.canPublish(&ev.Game) {
+		if ev.Type == EventAdd {
+			log.Printf("xwis: not publishing game %s: it must be hosted on the lobby address with the default port", key)
+		}
+		publish = false
+	}
+	if !publish {
+		if g != nil {
+			// connection is closed in background
+			delete(p.games, key)
+			g.cancel()
+		}
+		return
+	}
+	info := GameToXWIS(&ev.Game.Game)
+	if g != nil {
+		g.setInfo(info)
+		return
+	}
+	if len(p.games) >= p.max {
+		if ev.Type == EventAdd {
+			log.Printf("xwis: not publishing game %s: too many published games", key)
+		}
+		return
+	}
+	gctx, cancel := context.WithCancel(ctx)
+	login := p.loginFor(key)
+	g = &xwisGame{
+		login:  login,
+		room:   xwisRoom(login),
+		cancel: cancel,
+		done:   make(chan struct{}),
+		update: make(chan struct{}, 1),
+		info:   *info,
+	}
+	p.games[key] = g
+	p.rooms[g.room] = struct{}{}
+	go p.publish(gctx, key, g)
+}
+
+// publish the game on XWIS and keep it updated until the context is canceled.
+// The connection is reestablished with a backoff if it fails.
+func (p *XWISPublisher) publish(ctx context.Context, key gameKey, g *xwisGame) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.rooms, g.room)
+		p.mu.Unlock()
+		close(g.done)
+	}()
+	var (
+		c        *xwis.Client
+		game     *xwis.Game
+		last     xwis.GameInfo
+		failures int
+		force    bool
+	)
+	ticker := time.NewTicker(p.refresh)
+	defer ticker.Stop()
+	disconnect := func() {
+		if game != nil {
+			_ = game.Close()
+			game = nil
+			cntXWISPublished.Dec()
+		}
+		if c != nil {
+			_ = c.Close()
+			c = nil
+		}
+	}
+	defer disconnect()
+	for {
+		info := g.getInfo()
+		var err error
+		if game == nil {
+			c, err = xwis.NewClientWithAddress(ctx, p.addr, g.login, "")
+			if err == nil {
+				game, err = c.RegisterGame(ctx, info)
+				if err == nil {
+					cntXWISPublished.Inc()
+					log.Printf("xwis: published game %s as %q", key, g.login)
+				}
+			}
+		} else if force || !reflect.DeepEqual(info, last) {
+			err = game.Update(ctx, info)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			log.Printf("xwis: cannot publish game %s: %v", key, err)
+			disconnect()
+			if sleepCtx(ctx, p.retry.backoff(failures)) != nil {
+				return
+			}
+			continue
+		}
+		failures = 0
+		last = info
+		select {
+		case <-ctx.Done():
+			return
+		case <-g.update:
+			force = false
+		case <-ticker.C:
+			// detects broken connections
+			force = true
+		}
+	}
+}
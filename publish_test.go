@@ -0,0 +1,247 @@
+package lobby
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noxworld-dev/xwis"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeXWIS is a minimal XWIS IRC server. It supports logging in, hosting games and listing them.
+type fakeXWIS struct {
+	ln     net.Listener
+	mu     sync.Mutex
+	topics map[string]string
+	conns  map[net.Conn]struct{}
+}
+
+func newFakeXWIS(t testing.TB) *fakeXWIS {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeXWIS{
+		ln:     ln,
+		topics: make(map[string]string),
+		conns:  make(map[net.Conn]struct{}),
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+		s.dropAll()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns[conn] = struct{}{}
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeXWIS) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Rooms returns IDs of all hosted game rooms.
+func (s *fakeXWIS) Rooms() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for ch := range s.topics {
+		out = append(out, ch)
+	}
+	return out
+}
+
+// dropAll closes all client connections.
+func (s *fakeXWIS) dropAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *fakeXWIS) serve(conn net.Conn) {
+	var (
+		nick  string
+		rooms []string
+	)
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, ch := range rooms {
+			delete(s.topics, ch)
+		}
+		delete(s.conns, conn)
+		_ = conn.Close()
+	}()
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		cmd, args, _ := strings.Cut(sc.Text(), " ")
+		switch cmd {
+		case "NICK":
+			nick = args
+		case "USER":
+			fmt.Fprintf(conn, ":xwis 376 %s :End of MOTD\n", nick)
+		case "JOINGAME":
+			ch, _, _ := strings.Cut(args, " ")
+			rooms = append(rooms, ch)
+			fmt.Fprintf(conn, ":xwis 366 %s %s :End of NAMES\n", nick, ch)
+		case "TOPIC":
+			ch, payload, _ := strings.Cut(args, " ")
+			s.mu.Lock()
+			s.topics[ch] = payload
+			s.mu.Unlock()
+		case "PART":
+			s.mu.Lock()
+			delete(s.topics, args)
+			s.mu.Unlock()
+		case "LIST":
+			s.mu.Lock()
+			for ch, payload := range s.topics {
+				// the server adds 4 bytes before the game info, and reports the host IP (127.0.0.1)
+				fmt.Fprintf(conn, ":xwis 326 %s %s 1 0 37 1 0 2130706433 :xxxx%s\n", nick, ch, payload)
+			}
+			s.mu.Unlock()
+			fmt.Fprintf(conn, ":xwis 323 %s :End of LIST\n", nick)
+		case "QUIT":
+			return
+		}
+	}
+}
+
+func TestXWISPublisher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := newFakeXWIS(t)
+	svc := NewLobby()
+	svc.AddHostKey("host-key")
+	pub := NewXWISPublisher(srv.Addr())
+	pub.retry = RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: testTimeout}
+	pub.refresh = testTimeout
+	require.NoError(t, pub.SetPublicAddrs("2.2.2.2", "3.3.3.3"))
+	pub.SetMaxGames(1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- pub.Run(ctx, svc)
+	}()
+	register := func(g Game) {
+		_, err := svc.RegisterGameWithToken(ctx, &g, "host-key")
+		require.NoError(t, err)
+	}
+
+	g1 := initServers[1]
+	g1.PublishXWIS = true
+	register(g1)
+	// not published
+	register(initServers[2])
+
+	// publishing requires a host key
+	g4 := initServers[3]
+	g4.PublishXWIS = true
+	_, err := svc.RegisterGameWithToken(ctx, &g4, "")
+	require.ErrorIs(t, err, ErrInvalidToken)
+	// games that are not hosted on the lobby address or use other ports are not reachable via XWIS
+	register(g4)
+	g5 := initServers[1]
+	g5.Port = 18600
+	g5.PublishXWIS = true
+	register(g5)
+	// the number of published games is limited
+	g3 := initServers[2]
+	g3.PublishXWIS = true
+	register(g3)
+
+	c, err := xwis.NewClientWithAddress(ctx, srv.Addr(), "test", "")
+	require.NoError(t, err)
+	defer c.Close()
+	listXWIS := func() []Game {
+		list, err := NewXWISWithClient(c).ListGames(ctx)
+		require.NoError(t, err)
+		var out []Game
+		for _, g := range list {
+			out = append(out, g.Game)
+		}
+		return out
+	}
+	expXWIS := func(g Game) Game {
+		return Game{
+			Name: g.Name, Address: "127.0.0.1", Port: DefaultGamePort, Map: g.Map, Mode: g.Mode,
+			Access: AccessOpen, Res: Resolution{Width: 640, Height: 480}, Players: PlayersInfo{Cur: g.Players.Cur, Max: 31},
+		}
+	}
+	require.Eventually(t, func() bool {
+		return len(srv.Rooms()) == 1
+	}, time.Second, testTimeout/10)
+	// make sure other games are not published later
+	time.Sleep(testTimeout)
+	require.Equal(t, []Game{expXWIS(g1)}, listXWIS())
+	require.True(t, pub.Published(srv.Rooms()[0]))
+
+	// published games are skipped when listing XWIS
+	list, err := NewXWISWithPublisher(c, pub).ListGames(ctx)
+	require.NoError(t, err)
+	require.Empty(t, list)
+
+	// updates are published
+	g1.Players.Cur = 3
+	register(g1)
+	require.Eventually(t, func() bool {
+		list := listXWIS()
+		return len(list) == 1 && list[0].Players.Cur == 3
+	}, time.Second, testTimeout/10)
+
+	// game is published again when the connection fails
+	srv.dropAll()
+	require.Eventually(t, func() bool {
+		return len(srv.Rooms()) == 0
+	}, time.Second, testTimeout/10)
+	require.Eventually(t, func() bool {
+		return len(srv.Rooms()) == 1
+	}, time.Second, testTimeout/10)
+	c, err = xwis.NewClientWithAddress(ctx, srv.Addr(), "test", "")
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, []Game{expXWIS(g1)}, listXWIS())
+
+	// game is removed when it opts out
+	g1.PublishXWIS = false
+	register(g1)
+	require.Eventually(t, func() bool {
+		return len(srv.Rooms()) == 0
+	}, time.Second, testTimeout/10)
+
+	// or when it is removed from the lobby
+	g1.PublishXWIS = true
+	register(g1)
+	require.Eventually(t, func() bool {
+		return len(srv.Rooms()) == 1
+	}, time.Second, testTimeout/10)
+	require.NoError(t, svc.UnregisterGameWithToken(ctx, g1.Address, g1.Port, "host-key"))
+	require.Eventually(t, func() bool {
+		return len(srv.Rooms()) == 0
+	}, time.Second, testTimeout/10)
+
+	// all games are removed when the publisher stops
+	register(g1)
+	require.Eventually(t, func() bool {
+		return len(srv.Rooms()) == 1
+	}, time.Second, testTimeout/10)
+	cancel()
+	require.ErrorIs(t, <-errc, context.Canceled)
+	require.Eventually(t, func() bool {
+		return len(srv.Rooms()) == 0
+	}, time.Second, testTimeout/10)
+}
@@ -0,0 +1,78 @@
+package lobby
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a token bucket rate limiter.
+type RateLimit struct {
+	// Rate is a number of requests per second. Zero disables the limit.
+	Rate float64
+	// Burst is a max number of requests allowed at once. It is set to 1 if not set.
+	Burst int
+}
+
+// rateLimiter is a set of token buckets, one for each key.
+type rateLimiter struct {
+	limit RateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	lastClean time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	return &rateLimiter{limit: limit, buckets: make(map[string]*rateBucket)}
+}
+
+// allow checks if the request with a given key is allowed.
+// If it's not, the function returns the duration after which the request can be retried.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	burst := float64(l.limit.Burst)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cleanup(now)
+	b := l.buckets[key]
+	if b == nil {
+		b = &rateBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// cleanup removes buckets that are full already. It must be called with the lock held.
+func (l *rateLimiter) cleanup(now time.Time) {
+	if now.Sub(l.lastClean) < time.Minute {
+		return
+	}
+	l.lastClean = now
+	full := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package lobby
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(RateLimit{Rate: 2, Burst: 3})
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		ok, _ := l.allow("a", now)
+		require.True(t, ok)
+	}
+	ok, wait := l.allow("a", now)
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	// other keys are not affected
+	ok, _ = l.allow("b", now)
+	require.True(t, ok)
+
+	ok, _ = l.allow("a", now.Add(wait))
+	require.True(t, ok)
+
+	// disabled limiter
+	l = newRateLimiter(RateLimit{})
+	ok, _ = l.allow("a", now)
+	require.True(t, ok)
+}
+
+func TestServerRateLimit(t *testing.T) {
+	api := NewServer(NewLobby())
+	api.SetRateLimits(RateLimit{Rate: 0.1, Burst: 1}, RateLimit{Rate: 0.1, Burst: 2}, true)
+
+	do := func(path, agent string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", agent)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+	require.Equal(t, http.StatusOK, do("/api/v0/games/list", "a").StatusCode)
+	require.Equal(t, http.StatusOK, do("/api/v0/games/list", "a").StatusCode)
+	resp := do("/api/v0/games/list", "a")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "10", resp.Header.Get("Retry-After"))
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	// different agent and a different budget
+	require.Equal(t, http.StatusOK, do("/api/v0/games/list", "b").StatusCode)
+	require.Equal(t, http.StatusMethodNotAllowed, do("/api/v0/games/register", "a").StatusCode)
+	require.Equal(t, http.StatusTooManyRequests, do("/api/v0/games/register", "a").StatusCode)
+}
+
+func TestServerMetricLabels(t *testing.T) {
+	api := NewServer(NewLobby())
+	for _, c := range []struct {
+		method, path, agent string
+		exp                 [3]string
+	}{
+		{http.MethodGet, "/api/v0/games/list", "OpenNox/1.9.0", [3]string{"GET", "/api/v0/games/list", "opennox"}},
+		{http.MethodPost, "/api/v0/games/register", "nox-lobby-register/1.0", [3]string{"POST", "/api/v0/games/register", "nox-lobby"}},
+		{http.MethodGet, "/api/v0/random/12345", "Mozilla/5.0", [3]string{"GET", labelOther, "browser"}},
+		{"FOOBAR", "/api/v0/address", "curl/8.0", [3]string{labelOther, "/api/v0/address", labelOther}},
+		{http.MethodGet, "/", "", [3]string{"GET", labelOther, "none"}},
+	} {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.agent != "" {
+			req.Header.Set("User-Agent", c.agent)
+		}
+		got := [3]string{methodLabel(req), api.endpointLabel(req), agentLabel(req)}
+		require.Equal(t, c.exp, got, "%s %s", c.method, c.path)
+	}
+}
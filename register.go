@@ -0,0 +1,245 @@
+package lobby
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRegisterFailures is a default number of consecutive failures tolerated by KeepRegisteredWith.
+const DefaultMaxRegisterFailures = 3
+
+// RegisterStatus is a status of the game registration reported by KeepRegisteredWith.
+type RegisterStatus string
+
+const (
+	// StatusRegistered is reported when the game is registered for the first time.
+	StatusRegistered = RegisterStatus("registered")
+	// StatusFailed is reported for each failed registration attempt.
+	StatusFailed = RegisterStatus("failed")
+	// StatusRecovered is reported when the game is registered again after failures.
+	StatusRecovered = RegisterStatus("recovered")
+)
+
+// RegisterEvent is an event reported by KeepRegisteredWith.
+type RegisterEvent struct {
+	Status RegisterStatus
+	// Index of the Registerer in KeepRegisteredOptions.Registerers.
+	Index      int
+	Registerer Registerer
+	// Game is the game information sent to the Registerer.
+	Game *Game
+	// Err is set for failed registrations.
+	Err error
+	// Failures is a number of consecutive failures, including this one.
+	Failures int
+	// GaveUp is set if the Registerer won't be retried anymore. See KeepRegisteredOptions.MaxFailures.
+	GaveUp bool
+}
+
+// KeepRegisteredOptions configures KeepRegisteredWith.
+type KeepRegisteredOptions struct {
+	// Registerers to keep the game registered on. Registrations happen in parallel.
+	Registerers []Registerer
+	// Update sets a pace for updates. If it's not set, Interval is used.
+	Update <-chan time.Time
+	// Interval between updates. Default is DefaultTimeout/3.
+	Interval time.Duration
+	// InfoTimeout is a timeout for GameHost.GameInfo and for unregistering the game. Default is DefaultTimeout/3.
+	InfoTimeout time.Duration
+	// MaxFailures is a number of consecutive failures tolerated for each Registerer before giving up on it.
+	// Zero means DefaultMaxRegisterFailures, negative value disables the limit.
+	MaxFailures int
+	// CountTemporary enables counting temporary failures (network errors, server errors, rate limits) towards
+	// MaxFailures. By default, only rejected registrations are counted.
+	CountTemporary bool
+	// MinBackoff enables retrying failed registrations before the next update. The delay starts from MinBackoff
+	// and doubles with each failure, up to MaxBackoff. By default, registrations are retried on the next update.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnEvent is called when the registration status changes. Calls are serialized.
+	OnEvent func(ev RegisterEvent)
+}
+
+// KeepRegisteredWith keeps registering the game on multiple Registerers, so that it doesn't expire.
+//
+// Once the update triggers, GameHost.GameInfo is called to acquire fresh game info, which is then sent
+// to all Registerers in parallel.
+//
+// The function returns when context is canceled, if an error is returned from GameHost.GameInfo,
+// or if all Registerers failed more than MaxFailures times in a row. In the last case, the last error is returned.
+//
+// If Registerer implements Unregisterer, the game is unregistered when the function returns.
+func KeepRegisteredWith(ctx context.Context, h GameHost, opts *KeepRegisteredOptions) error {
+	o := *opts
+	if len(o.Registerers) == 0 {
+		return errors.New("no registerers")
+	}
+	if o.Interval <= 0 {
+		o.Interval = DefaultTimeout / 3
+	}
+	if o.InfoTimeout <= 0 {
+		o.InfoTimeout = DefaultTimeout / 3
+	}
+	if o.MaxFailures == 0 {
+		o.MaxFailures = DefaultMaxRegisterFailures
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = o.MinBackoff
+	}
+	update := o.Update
+	if update == nil {
+		ticker := time.NewTicker(o.Interval)
+		defer ticker.Stop()
+		update = ticker.C
+	}
+	var emitMu sync.Mutex
+	emit := func(ev RegisterEvent) {
+		if o.OnEvent == nil {
+			return
+		}
+		emitMu.Lock()
+		defer emitMu.Unlock()
+		o.OnEvent(ev)
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	done := make(chan error, len(o.Registerers))
+	workers := make([]*keepWorker, 0, len(o.Registerers))
+	for i, l := range o.Registerers {
+		w := &keepWorker{idx: i, l: l, opts: &o, emit: emit, info: make(chan *Game, 1)}
+		workers = append(workers, w)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done <- w.run(wctx)
+		}()
+	}
+	stop := func() {
+		cancel()
+		wg.Wait()
+	}
+	remaining := len(workers)
+	for {
+		ictx, icancel := context.WithTimeout(ctx, o.InfoTimeout)
+		info, err := h.GameInfo(ictx)
+		icancel()
+		if err != nil {
+			stop()
+			return err
+		}
+		for _, w := range workers {
+			w.send(info)
+		}
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				stop()
+				return ctx.Err()
+			case err := <-done:
+				remaining--
+				if remaining == 0 {
+					stop()
+					return err
+				}
+			case <-update:
+				break wait
+			}
+		}
+	}
+}
+
+// keepWorker registers the game on a single Registerer for KeepRegisteredWith.
+type keepWorker struct {
+	idx  int
+	l    Registerer
+	opts *KeepRegisteredOptions
+	emit func(ev RegisterEvent)
+	info chan *Game
+}
+
+// send sets new game info for the worker, replacing the pending one.
+func (w *keepWorker) send(g *Game) {
+	for {
+		select {
+		case w.info <- g:
+			return
+		default:
+		}
+		select {
+		case <-w.info:
+		default:
+		}
+	}
+}
+
+func (w *keepWorker) run(ctx context.Context) error {
+	var (
+		cur, last  *Game
+		registered bool
+		failures   int // all consecutive failures
+		counted    int // consecutive failures counted towards the limit
+		retry      <-chan time.Time
+		timer      *time.Timer
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	backoff := RetryPolicy{MinBackoff: w.opts.MinBackoff, MaxBackoff: w.opts.MaxBackoff}
+	for {
+		select {
+		case <-ctx.Done():
+			if u, ok := w.l.(Unregisterer); ok && last != nil {
+				uctx, cancel := context.WithTimeout(context.Background(), w.opts.InfoTimeout)
+				_ = u.UnregisterGame(uctx, last.Address, last.Port)
+				cancel()
+			}
+			return ctx.Err()
+		case cur = <-w.info:
+		case <-retry:
+		}
+		if timer != nil {
+			timer.Stop()
+			timer, retry = nil, nil
+		}
+		g := cur.Clone()
+		err := w.l.RegisterGame(ctx, g)
+		if ctx.Err() != nil {
+			continue
+		}
+		ev := RegisterEvent{Index: w.idx, Registerer: w.l, Game: g}
+		if err == nil {
+			last = g
+			ev.Status = StatusRecovered
+			if !registered {
+				ev.Status = StatusRegistered
+			} else if failures == 0 {
+				continue
+			}
+			registered = true
+			failures, counted = 0, 0
+			w.emit(ev)
+			continue
+		}
+		failures++
+		if w.opts.CountTemporary || !isTemporary(err) {
+			counted++
+		}
+		ev.Status, ev.Err, ev.Failures = StatusFailed, err, failures
+		ev.GaveUp = w.opts.MaxFailures > 0 && counted > w.opts.MaxFailures
+		w.emit(ev)
+		if ev.GaveUp {
+			return err
+		}
+		if w.opts.MinBackoff > 0 {
+			timer = time.NewTimer(backoff.backoff(failures))
+			retry = timer.C
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package lobby
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepRegisteredWith(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l1 := NewLobby()
+	l2 := &failingRegisterer{Lobby: NewLobby()}
+	l2.setErr(&APIError{Status: http.StatusServiceUnavailable, Message: "unavailable"})
+	l3 := &failingRegisterer{Lobby: NewLobby()}
+	l3.setErr(ErrBanned)
+
+	var (
+		mu     sync.Mutex
+		events [3][]RegisterStatus
+		gaveUp *RegisterEvent
+	)
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- KeepRegisteredWith(ctx, testGameHost{info: &server1}, &KeepRegisteredOptions{
+			Registerers: []Registerer{l1, l2, l3},
+			Update:      ticker.C,
+			MaxFailures: 2,
+			MinBackoff:  time.Millisecond,
+			MaxBackoff:  testTimeout / 10,
+			OnEvent: func(ev RegisterEvent) {
+				mu.Lock()
+				defer mu.Unlock()
+				events[ev.Index] = append(events[ev.Index], ev.Status)
+				if ev.GaveUp {
+					// checked on the test goroutine
+					gaveUp = &ev
+				}
+			},
+		})
+	}()
+	getEvents := func(i int) []RegisterStatus {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]RegisterStatus{}, events[i]...)
+	}
+
+	// registered immediately
+	require.Eventually(t, func() bool {
+		return len(getEvents(0)) == 1
+	}, time.Second, testTimeout/10)
+	require.Equal(t, []RegisterStatus{StatusRegistered}, getEvents(0))
+	expectServers(t, l1, []Game{server1})
+
+	// rejected registrations are retried with backoff, until the limit is reached
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return gaveUp != nil
+	}, time.Second, testTimeout/10)
+	mu.Lock()
+	last := *gaveUp
+	mu.Unlock()
+	require.Equal(t, 2, last.Index)
+	require.Equal(t, 3, last.Failures)
+	require.ErrorIs(t, last.Err, ErrBanned)
+	require.Equal(t, []RegisterStatus{StatusFailed, StatusFailed, StatusFailed}, getEvents(2))
+
+	// temporary failures are not limited
+	require.Eventually(t, func() bool {
+		return len(getEvents(1)) > 5
+	}, time.Second, testTimeout/10)
+	l2.setErr(nil)
+	require.Eventually(t, func() bool {
+		ev := getEvents(1)
+		return ev[len(ev)-1] == StatusRegistered
+	}, time.Second, testTimeout/10)
+	expectServers(t, l2, []Game{server1})
+
+	// failures after the first registration
+	l2.setErr(&APIError{Status: http.StatusServiceUnavailable, Message: "unavailable"})
+	ticker.Reset(testTimeout / 10)
+	require.Eventually(t, func() bool {
+		ev := getEvents(1)
+		return ev[len(ev)-1] == StatusFailed
+	}, time.Second, testTimeout/10)
+	l2.setErr(nil)
+	require.Eventually(t, func() bool {
+		ev := getEvents(1)
+		return ev[len(ev)-1] == StatusRecovered
+	}, time.Second, testTimeout/10)
+	require.Equal(t, []RegisterStatus{StatusRegistered}, getEvents(0))
+
+	cancel()
+	require.ErrorIs(t, <-errc, context.Canceled)
+	// games are removed when the function returns
+	expectServers(t, l1, nil)
+}
+
+func TestKeepRegisteredGiveUp(t *testing.T) {
+	ctx := context.Background()
+	l := &failingRegisterer{Lobby: NewLobby()}
+	l.setErr(ErrInvalidToken)
+	err := KeepRegisteredWith(ctx, testGameHost{info: &server1}, &KeepRegisteredOptions{
+		Registerers: []Registerer{l},
+		Interval:    time.Hour,
+		MaxFailures: 1,
+		MinBackoff:  time.Millisecond,
+	})
+	require.ErrorIs(t, err, ErrInvalidToken)
+	require.EqualValues(t, 2, l.calls)
+}
@@ -0,0 +1,213 @@
+package lobby
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by the Client when all lobby servers are temporarily skipped after repeated failures.
+var ErrCircuitOpen = errors.New("lobby is unavailable: circuit breaker is open")
+
+// DefaultRetryPolicy is a RetryPolicy used by the Client by default.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:      4,
+	MinBackoff:       250 * time.Millisecond,
+	MaxBackoff:       5 * time.Second,
+	BreakerThreshold: 5,
+	BreakerCooldown:  30 * time.Second,
+}
+
+// RetryPolicy controls retries and failover of the Client requests.
+//
+// Only idempotent requests are retried: all listing requests, game registration and removal.
+// Other requests are sent once to the first available server.
+type RetryPolicy struct {
+	// MaxAttempts is a max number of attempts for a single request, including the first one.
+	// Zero or one disables retries.
+	MaxAttempts int
+	// MinBackoff is a delay before the first retry. It doubles with each attempt, up to MaxBackoff.
+	// Random jitter of up to a half of the delay is applied.
+	MinBackoff time.Duration
+	// MaxBackoff is a max delay between attempts. Requests are not retried if the server asks
+	// to wait longer than this with Retry-After header.
+	MaxBackoff time.Duration
+	// BreakerThreshold is a number of consecutive failures after which the server is skipped for BreakerCooldown.
+	// Zero disables the circuit breaker.
+	BreakerThreshold int
+	// BreakerCooldown is a time the server is skipped for after repeated failures.
+	BreakerCooldown time.Duration
+}
+
+// backoff returns a delay before a given retry, starting from 1.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + jitter(d/2)
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter returns a random duration in [0, d].
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(d) + 1))
+}
+
+// lobbyServer is a single lobby URL used by the Client, with the state of its circuit breaker.
+type lobbyServer struct {
+	url       string
+	failures  int
+	openUntil time.Time
+}
+
+// SetServerURLs sets lobby URLs used by the client. The first URL is preferred, while the rest are used for failover.
+// Client switches to the next URL if the current one fails, and keeps using it until it fails as well.
+func (c *Client) SetServerURLs(urls ...string) {
+	servers := make([]*lobbyServer, 0, len(urls))
+	for _, u := range urls {
+		servers = append(servers, &lobbyServer{url: u})
+	}
+	c.mu.Lock()
+	c.servers = servers
+	c.cur = 0
+	c.mu.Unlock()
+}
+
+// SetRetryPolicy sets retry and failover policy for the client. See DefaultRetryPolicy.
+func (c *Client) SetRetryPolicy(p RetryPolicy) {
+	c.mu.Lock()
+	c.retry = p
+	c.mu.Unlock()
+}
+
+// retryPolicy returns current retry policy.
+func (c *Client) retryPolicy() RetryPolicy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.retry
+}
+
+// pickServer returns the current server, skipping ones with an open circuit breaker.
+// It returns nil if all servers are skipped.
+func (c *Client) pickServer(now time.Time) *lobbyServer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.servers {
+		j := (c.cur + i) % len(c.servers)
+		if s := c.servers[j]; !now.Before(s.openUntil) {
+			c.cur = j
+			return s
+		}
+	}
+	return nil
+}
+
+// reportResult updates the circuit breaker of the server and switches to the next server on failures.
+func (c *Client) reportResult(s *lobbyServer, err error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil || !isTemporary(err) {
+		s.failures = 0
+		s.openUntil = time.Time{}
+		return
+	}
+	s.failures++
+	if c.servers[c.cur] == s {
+		c.cur = (c.cur + 1) % len(c.servers)
+	}
+	var aerr *APIError
+	if errors.As(err, &aerr) && aerr.RetryAfter > 0 {
+		s.openUntil = now.Add(aerr.RetryAfter)
+	} else if c.retry.BreakerThreshold > 0 && s.failures >= c.retry.BreakerThreshold {
+		s.openUntil = now.Add(c.retry.BreakerCooldown)
+	}
+}
+
+// isIdempotent checks if the request can be safely retried.
+func isIdempotent(meth, path string) bool {
+	switch meth {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	switch path {
+	case "/api/v0/games/register", "/api/v0/games/unregister":
+		// registrations are replaced as a whole
+		return true
+	}
+	return false
+}
+
+// isTemporary checks if the error is caused by the lobby being temporarily unavailable or overloaded.
+// Such requests can be retried later.
+func isTemporary(err error) bool {
+	var aerr *APIError
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrCircuitOpen):
+		return true
+	case errors.As(err, &aerr):
+		return aerr.Status >= 500 && aerr.Status != http.StatusNotImplemented
+	case errors.Is(err, context.Canceled):
+		return false
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return false
+		}
+	}
+	// network and decoding errors
+	return true
+}
+
+// parseRetryAfter parses Retry-After header value, either in seconds or as HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(v); err == nil {
+		if sec <= 0 {
+			return 0
+		}
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// sleepCtx waits for a given duration or until the context is canceled.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
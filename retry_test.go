@@ -0,0 +1,239 @@
+package lobby
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:      3,
+	MinBackoff:       time.Millisecond,
+	MaxBackoff:       testTimeout,
+	BreakerThreshold: 3,
+	BreakerCooldown:  time.Hour,
+}
+
+// flakyServer fails a given number of requests with a status code before passing them to the handler.
+// If body is set, it is written as a JSON response of failed requests.
+type flakyServer struct {
+	h          http.Handler
+	calls      int32
+	fail       int32
+	status     int
+	retryAfter string
+	body       string
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := atomic.AddInt32(&s.calls, 1)
+	if n <= atomic.LoadInt32(&s.fail) {
+		if s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		if s.body != "" {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(s.status)
+		_, _ = w.Write([]byte(s.body))
+		return
+	}
+	s.h.ServeHTTP(w, r)
+}
+
+func newFlakyServer(t testing.TB, fail int32, status int) (*flakyServer, string) {
+	fs := &flakyServer{h: NewServer(NewLobby()), fail: fail, status: status}
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+	return fs, srv.URL
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for i, exp := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		exp *= time.Millisecond
+		d := p.backoff(i + 1)
+		require.True(t, d >= exp/2 && d <= exp, "%d: %v", i+1, d)
+	}
+	require.Equal(t, 5*time.Second, parseRetryAfter("5", time.Now()))
+	now := time.Now()
+	require.Equal(t, 10*time.Second, parseRetryAfter(now.Add(10*time.Second).UTC().Format(http.TimeFormat), now.Truncate(time.Second)))
+	require.Zero(t, parseRetryAfter("bad", now))
+}
+
+func TestClientRetry(t *testing.T) {
+	ctx := context.Background()
+	fs, url := newFlakyServer(t, 2, http.StatusServiceUnavailable)
+	c := NewClient(url)
+	c.SetRetryPolicy(testRetryPolicy)
+
+	// idempotent requests are retried
+	_, err := c.ListGames(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, atomic.LoadInt32(&fs.calls))
+
+	// other requests are not
+	atomic.StoreInt32(&fs.calls, 0)
+	err = c.doRequest(ctx, "POST", "/api/v0/admin/refresh", "", nil, &Response{})
+	require.Error(t, err)
+	require.EqualValues(t, 1, atomic.LoadInt32(&fs.calls))
+
+	// validation errors are not retried
+	atomic.StoreInt32(&fs.calls, 10)
+	err = c.sendRequest(ctx, "POST", "/api/v0/games/register", Game{Name: server1.Name}, nil)
+	require.ErrorIs(t, err, ErrInvalidGame)
+	require.EqualValues(t, 11, atomic.LoadInt32(&fs.calls))
+}
+
+func TestClientRetryErrorBody(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	l.SetTokenAuth(true)
+	api := NewServer(l)
+	api.trustAddr = true
+	fs := &flakyServer{h: api, fail: 1, status: http.StatusServiceUnavailable, body: `{"error":"overloaded"}`}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+	c.SetRetryPolicy(testRetryPolicy)
+
+	// error of the failed attempt must not be returned after a successful retry
+	g := server1
+	require.NoError(t, c.RegisterGame(ctx, &g))
+	require.EqualValues(t, 2, atomic.LoadInt32(&fs.calls))
+	require.NotEmpty(t, c.tokenFor(srv.URL, g.gameKey()))
+
+	atomic.StoreInt32(&fs.calls, 0)
+	list, err := c.ListGames(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	ctx := context.Background()
+	fs, url := newFlakyServer(t, 1, http.StatusTooManyRequests)
+	fs.retryAfter = "60"
+	c := NewClient(url)
+	c.SetRetryPolicy(testRetryPolicy)
+
+	// server asks to wait longer than the max backoff
+	_, err := c.ListGames(ctx)
+	require.ErrorIs(t, err, ErrRateLimited)
+	var aerr *APIError
+	require.True(t, errors.As(err, &aerr))
+	require.Equal(t, time.Minute, aerr.RetryAfter)
+	require.EqualValues(t, 1, atomic.LoadInt32(&fs.calls))
+
+	// the server is skipped until then
+	_, err = c.ListGames(ctx)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.EqualValues(t, 1, atomic.LoadInt32(&fs.calls))
+}
+
+func TestClientFailover(t *testing.T) {
+	ctx := context.Background()
+	fs1, url1 := newFlakyServer(t, 1000, http.StatusBadGateway)
+	fs2, url2 := newFlakyServer(t, 0, 0)
+	c := NewClient(url1)
+	c.SetServerURLs(url1, url2)
+	p := testRetryPolicy
+	p.MaxAttempts = 2
+	c.SetRetryPolicy(p)
+
+	_, err := c.ListGames(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, atomic.LoadInt32(&fs1.calls))
+	require.EqualValues(t, 1, atomic.LoadInt32(&fs2.calls))
+
+	// client sticks to the working server
+	_, err = c.ListGames(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, atomic.LoadInt32(&fs1.calls))
+	require.EqualValues(t, 2, atomic.LoadInt32(&fs2.calls))
+}
+
+func TestClientBreaker(t *testing.T) {
+	ctx := context.Background()
+	fs, url := newFlakyServer(t, 2, http.StatusInternalServerError)
+	c := NewClient(url)
+	p := testRetryPolicy
+	p.MaxAttempts = 1
+	p.BreakerThreshold = 2
+	c.SetRetryPolicy(p)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.ListGames(ctx)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	_, err := c.ListGames(ctx)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.EqualValues(t, 2, atomic.LoadInt32(&fs.calls))
+
+	// half-open after cooldown
+	c.mu.Lock()
+	c.servers[0].openUntil = time.Now()
+	c.mu.Unlock()
+	_, err = c.ListGames(ctx)
+	require.NoError(t, err)
+}
+
+// failingRegisterer fails registrations with a given error until it's cleared.
+type failingRegisterer struct {
+	Lobby
+	mu    sync.Mutex
+	err   error
+	calls int32
+}
+
+func (r *failingRegisterer) setErr(err error) {
+	r.mu.Lock()
+	r.err = err
+	r.mu.Unlock()
+}
+
+func (r *failingRegisterer) RegisterGame(ctx context.Context, g *Game) error {
+	atomic.AddInt32(&r.calls, 1)
+	r.mu.Lock()
+	err := r.err
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return r.Lobby.RegisterGame(ctx, g)
+}
+
+func TestKeepRegisteredOutage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := &failingRegisterer{Lobby: NewLobby()}
+	l.setErr(&APIError{Status: http.StatusBadGateway, Message: "bad gateway"})
+	ticker := time.NewTicker(testTimeout / 10)
+	defer ticker.Stop()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- KeepRegistered(ctx, l, ticker.C, testGameHost{info: &server1})
+	}()
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&l.calls) > 5
+	}, time.Second, testTimeout/10)
+	select {
+	case err := <-errc:
+		t.Fatal("unexpected return:", err)
+	default:
+	}
+	l.setErr(ErrBanned)
+	select {
+	case err := <-errc:
+		require.ErrorIs(t, err, ErrBanned)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
@@ -1,18 +1,39 @@
 package lobby
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var _ http.Handler = (*Server)(nil)
 
 // Server is an HTTP Nox lobby server.
 type Server struct {
-	l         Lobby
+	l       Lobby
+	w       Watcher
+	hist    *History
+	players PlayerFinder
+
+	limitRegister *rateLimiter
+	limitList     *rateLimiter
+	limitByAgent  bool
+	proxies       TrustedProxies
+
+	adminKey  string
+	svc       *Service
+	sources   []adminSource
+	bans      *BanList
+	lobbyBans banLister // ban list is read on each request, since it can be set after creating the server
+
 	mux       *http.ServeMux
 	trustAddr bool // trust IP sent by a remote
 }
@@ -22,6 +43,18 @@ type IPResp struct {
 	IP string `json:"ip"`
 }
 
+// RegisterResp represents a response to the game registration request.
+type RegisterResp struct {
+	// Token must be sent with the following registrations of the same game. See TokenRegisterer.
+	Token string `json:"token,omitempty"`
+}
+
+// UnregisterReq represents a request to remove the game registration.
+type UnregisterReq struct {
+	Address string `json:"addr,omitempty"`
+	Port    int    `json:"port,omitempty"`
+}
+
 // ServerListResp represents a response to the server list request.
 type ServerListResp []GameInfo
 
@@ -29,39 +62,186 @@ type ServerListResp []GameInfo
 type Response struct {
 	Result interface{} `json:"data,omitempty"`
 	Err    string      `json:"error,omitempty"`
+	// Code is a stable error code, set together with Err. Clients should check it instead of the message.
+	Code ErrorCode `json:"code,omitempty"`
+	// Field is a name of the invalid field, set for CodeInvalidGame errors. See ValidationError.
+	Field string `json:"field,omitempty"`
+	// Next is a cursor for the next page of the paginated response.
+	Next string `json:"next,omitempty"`
+	// Sources is a status of each game source, set for game lists of aggregated lobbies. See SourceLister.
+	Sources []SourceStatus `json:"sources,omitempty"`
 }
 
 // NewServer creates a new http.Handler from a Lobby implementation.
+//
+// If the Lobby implements Watcher, it will be used for streaming game list changes.
+// Otherwise, the game list will be polled periodically.
 func NewServer(l Lobby) *Server {
 	api := &Server{l: l, mux: http.NewServeMux()}
+	if f, ok := l.(PlayerFinder); ok {
+		api.players = f
+	}
+	if s, ok := l.(*Service); ok {
+		api.svc = s
+	}
+	if b, ok := l.(banLister); ok {
+		api.lobbyBans = b
+	}
+	if w, ok := l.(Watcher); ok {
+		api.w = w
+	} else {
+		api.w = PollWatcher(l, 0)
+	}
 	api.mux.HandleFunc("/api/v0/address", api.Address)
 	api.mux.HandleFunc("/api/v0/games/list", api.ServersList)
 	api.mux.HandleFunc("/api/v0/games/register", api.RegisterServer)
+	api.mux.HandleFunc("/api/v0/games/unregister", api.UnregisterServer)
+	api.mux.HandleFunc("/api/v0/games/watch", api.WatchServers)
+	api.mux.HandleFunc("/api/v0/players/find", api.FindPlayer)
+	api.mux.HandleFunc("/api/v0/stats/servers", api.StatsServers)
+	api.mux.HandleFunc("/api/v0/stats/maps", api.StatsMaps)
+	api.mux.HandleFunc("/api/v0/stats/players", api.StatsPlayers)
+	api.mux.HandleFunc("/api/v0/stats/sessions", api.StatsSessions)
+	api.mux.HandleFunc("/api/v0/admin/bans", api.AdminBans)
+	api.mux.HandleFunc("/api/v0/admin/games", api.AdminGames)
+	api.mux.HandleFunc("/api/v0/admin/games/expire", api.AdminExpireGame)
+	api.mux.HandleFunc("/api/v0/admin/timeout", api.AdminTimeout)
+	api.mux.HandleFunc("/api/v0/admin/sources", api.AdminSources)
+	api.mux.HandleFunc("/api/v0/admin/refresh", api.AdminRefresh)
 	return api
 }
 
+// SetWatcher sets a Watcher for streaming game list changes. It allows sharing the same PollWatcher
+// with other consumers. By default, the Lobby is used if it implements Watcher.
+func (api *Server) SetWatcher(w Watcher) {
+	api.w = w
+}
+
+// SetPlayerFinder sets an implementation for player search API, for example a PlayerIndex.
+// By default, the Lobby is used if it implements PlayerFinder.
+func (api *Server) SetPlayerFinder(f PlayerFinder) {
+	api.players = f
+}
+
+// SetHistory enables statistics API backed by the game history.
+// The caller is responsible for populating the history, see History.Run.
+func (api *Server) SetHistory(h *History) {
+	api.hist = h
+}
+
+// SetRateLimits sets rate limits for requests from a single IP address.
+// Register limit applies to game registration requests, while list limit applies to all other requests.
+// If byAgent is set, requests from different User-Agents on the same IP are limited separately.
+func (api *Server) SetRateLimits(register, list RateLimit, byAgent bool) {
+	api.limitRegister = newRateLimiter(register)
+	api.limitList = newRateLimiter(list)
+	api.limitByAgent = byAgent
+}
+
+// SetTrustedProxies sets a list of trusted reverse proxies.
+// For requests coming from these proxies, the client address is taken from Forwarded, X-Forwarded-For
+// or X-Real-IP headers (in that order).
+func (api *Server) SetTrustedProxies(p TrustedProxies) {
+	api.proxies = p
+}
+
+// checkRateLimit checks if the request is allowed by the rate limiter.
+// If it's not, an error response is written and false is returned.
+func (api *Server) checkRateLimit(w http.ResponseWriter, r *http.Request) bool {
+	lim := api.limitList
+	switch r.URL.Path {
+	case "/api/v0/games/register", "/api/v0/games/unregister":
+		lim = api.limitRegister
+	}
+	if lim == nil {
+		return true
+	}
+	key, err := api.getAddress(r)
+	if err != nil {
+		key = r.RemoteAddr
+	}
+	if api.limitByAgent {
+		key += "|" + r.Header.Get("User-Agent")
+	}
+	ok, wait := lim.allow(key, time.Now())
+	if ok {
+		return true
+	}
+	cntRateLimited.WithLabelValues(methodLabel(r), api.endpointLabel(r)).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	api.jsonError(w, http.StatusTooManyRequests, ErrRateLimited)
+	return false
+}
+
 func (api *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	cntRequests.WithLabelValues(r.Method, r.URL.Path, r.Header.Get("User-Agent")).Inc()
+	if !api.checkRateLimit(w, r) {
+		return
+	}
+	cntRequests.WithLabelValues(methodLabel(r), api.endpointLabel(r), agentLabel(r)).Inc()
+	if r.Header.Get(PeerHeader) != "" {
+		r = r.WithContext(withPeerRequest(r.Context()))
+	}
 	api.mux.ServeHTTP(w, r)
 }
 
+// labelOther is a metric label value for requests that do not match any known value.
+const labelOther = "other"
+
+// endpointLabel returns a metric label for the API endpoint. Unknown paths are reported as labelOther,
+// so that the number of metric series remains bounded.
+func (api *Server) endpointLabel(r *http.Request) string {
+	_, pattern := api.mux.Handler(r)
+	if pattern == "" {
+		return labelOther
+	}
+	return pattern
+}
+
+// methodLabel returns a metric label for the HTTP method.
+func methodLabel(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return r.Method
+	}
+	return labelOther
+}
+
+// agentLabel returns a metric label for the client type, based on the User-Agent header.
+func agentLabel(r *http.Request) string {
+	agent := strings.ToLower(r.Header.Get("User-Agent"))
+	switch {
+	case agent == "":
+		return "none"
+	case strings.HasPrefix(agent, "opennox"):
+		return "opennox"
+	case strings.HasPrefix(agent, "nox-lobby"):
+		return "nox-lobby"
+	case strings.HasPrefix(agent, "mozilla/"):
+		return "browser"
+	}
+	return labelOther
+}
+
 // jsonResponse writes response, wrapping it into JSON format.
 func (api *Server) jsonResponse(w http.ResponseWriter, code int, data interface{}) {
+	api.jsonResponseWith(w, code, &Response{Result: data})
+}
+
+// jsonResponseWith writes a successful response envelope in JSON format.
+func (api *Server) jsonResponseWith(w http.ResponseWriter, code int, resp *Response) {
 	if code == 0 {
 		code = http.StatusOK
 	}
-	if data == nil {
+	if resp.Result == nil {
 		// result field in JSON is omitempty, but we want to keep it for this case
-		data = (*struct{})(nil)
+		resp.Result = (*struct{})(nil)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
-	_ = json.NewEncoder(w).Encode(&Response{
-		Result: data,
-	})
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// jsonError writes an error, wrapping it into JSON format.
+// jsonError writes an error, wrapping it into JSON format. Error code is set based on the error and HTTP status.
 func (api *Server) jsonError(w http.ResponseWriter, code int, err error) {
 	if code == 0 {
 		code = http.StatusInternalServerError
@@ -69,19 +249,45 @@ func (api *Server) jsonError(w http.ResponseWriter, code int, err error) {
 	if err == nil {
 		err = errors.New(http.StatusText(code))
 	}
+	resp := &Response{
+		Err:  err.Error(),
+		Code: errorCode(code, err),
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		resp.Field = verr.Field
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
-	_ = json.NewEncoder(w).Encode(&Response{
-		Err: err.Error(),
-	})
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
 func (api *Server) getAddress(r *http.Request) (string, error) {
 	if r.RemoteAddr == "" {
 		return "", errors.New("cannot detect IP address")
 	}
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	return ip, err
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+	ip, err := parseAddr(host)
+	if err != nil {
+		return "", err
+	}
+	if len(api.proxies) != 0 && api.proxies.Contains(ip) {
+		ip = api.proxies.clientIP(r.Header, ip)
+	}
+	return ip.String(), nil
+}
+
+// bearerToken returns a token from the Authorization header.
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(h[len(prefix):])
 }
 
 func (api *Server) Address(w http.ResponseWriter, r *http.Request) {
@@ -101,8 +307,8 @@ func (api *Server) Address(w http.ResponseWriter, r *http.Request) {
 func (api *Server) RegisterServer(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		// set limit to avoid giant requests - 1MB
-		body := http.MaxBytesReader(w, r.Body, 1024*1024)
+		// set limit to avoid giant requests; valid games are much smaller
+		body := http.MaxBytesReader(w, r.Body, 64*1024)
 		var req Game
 		if err := json.NewDecoder(body).Decode(&req); err != nil {
 			api.jsonError(w, http.StatusBadRequest, err)
@@ -116,12 +322,61 @@ func (api *Server) RegisterServer(w http.ResponseWriter, r *http.Request) {
 			}
 			req.Address = addr
 		}
-		req.Map = strings.ToLower(req.Map)
-		err := api.l.RegisterGame(r.Context(), &req)
+		req.Normalize()
+		if err := req.Validate(); err != nil {
+			api.jsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		var (
+			token string
+			err   error
+		)
+		if tr, ok := api.l.(TokenRegisterer); ok {
+			token, err = tr.RegisterGameWithToken(r.Context(), &req, bearerToken(r))
+		} else {
+			err = api.l.RegisterGame(r.Context(), &req)
+		}
 		if err != nil {
+			api.jsonError(w, errorStatus(err, http.StatusBadRequest), err)
+			return
+		}
+		api.jsonResponse(w, 0, RegisterResp{Token: token})
+	default:
+		api.jsonError(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func (api *Server) UnregisterServer(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost, http.MethodDelete:
+		body := http.MaxBytesReader(w, r.Body, 1024)
+		var req UnregisterReq
+		if err := json.NewDecoder(body).Decode(&req); err != nil && err != io.EOF {
 			api.jsonError(w, http.StatusBadRequest, err)
 			return
 		}
+		if !api.trustAddr || req.Address == "" {
+			addr, err := api.getAddress(r)
+			if err != nil {
+				api.jsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			req.Address = addr
+		}
+		var err error
+		switch u := api.l.(type) {
+		case TokenUnregisterer:
+			err = u.UnregisterGameWithToken(r.Context(), req.Address, req.Port, bearerToken(r))
+		case Unregisterer:
+			err = u.UnregisterGame(r.Context(), req.Address, req.Port)
+		default:
+			api.jsonError(w, http.StatusNotImplemented, nil)
+			return
+		}
+		if err != nil {
+			api.jsonError(w, errorStatus(err, http.StatusBadRequest), err)
+			return
+		}
 		api.jsonResponse(w, 0, nil)
 	default:
 		api.jsonError(w, http.StatusMethodNotAllowed, nil)
@@ -131,7 +386,102 @@ func (api *Server) RegisterServer(w http.ResponseWriter, r *http.Request) {
 func (api *Server) ServersList(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet, http.MethodHead:
-		list, err := api.l.ListGames(r.Context())
+		opts, err := ListOptionsFromValues(r.URL.Query())
+		if err != nil {
+			api.jsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		var (
+			list    []GameInfo
+			sources []SourceStatus
+		)
+		if sl, ok := api.l.(SourceLister); ok {
+			list, sources, err = sl.ListGamesSources(r.Context())
+		} else {
+			list, err = api.l.ListGames(r.Context())
+		}
+		if err != nil {
+			api.jsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		list, next, err := FilterGames(list, opts)
+		if err != nil {
+			api.jsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		api.jsonResponseWith(w, 0, &Response{Result: ServerListResp(list), Next: next, Sources: sources})
+	default:
+		api.jsonError(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// watchPing is an interval for sending keep-alive comments to watch streams.
+const watchPing = 30 * time.Second
+
+// WatchServers streams game list changes as Server-Sent Events.
+// Each event has a type set to EventType and the data set to JSON-encoded GameEvent.
+func (api *Server) WatchServers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			api.jsonError(w, http.StatusInternalServerError, fmt.Errorf("streaming is %w", ErrNotImplemented))
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		events, err := api.w.WatchGames(ctx)
+		if err != nil {
+			api.jsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		ping := time.NewTicker(watchPing)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ping.C:
+				if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+					return
+				}
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(ev)
+				if err != nil {
+					return
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	default:
+		api.jsonError(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// FindPlayer returns the games a player with a given name is currently in.
+func (api *Server) FindPlayer(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if api.players == nil {
+			api.jsonError(w, http.StatusNotImplemented, fmt.Errorf("player search is disabled: %w", ErrNotImplemented))
+			return
+		}
+		name := r.URL.Query().Get("name")
+		if strings.TrimSpace(name) == "" {
+			api.jsonError(w, http.StatusBadRequest, errors.New("player name must be set"))
+			return
+		}
+		list, err := api.players.FindPlayer(r.Context(), name)
 		if err != nil {
 			api.jsonError(w, http.StatusInternalServerError, err)
 			return
@@ -141,3 +491,76 @@ func (api *Server) ServersList(w http.ResponseWriter, r *http.Request) {
 		api.jsonError(w, http.StatusMethodNotAllowed, nil)
 	}
 }
+
+// defaultStatsPeriod is a default period for statistics requests.
+const defaultStatsPeriod = 24 * time.Hour
+
+// statsQuery parses common parameters for statistics requests: "since" as a duration and "limit" as a number.
+func (api *Server) statsQuery(w http.ResponseWriter, r *http.Request) (time.Time, int, bool) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		api.jsonError(w, http.StatusMethodNotAllowed, nil)
+		return time.Time{}, 0, false
+	}
+	if api.hist == nil {
+		api.jsonError(w, http.StatusNotImplemented, fmt.Errorf("statistics are disabled: %w", ErrNotImplemented))
+		return time.Time{}, 0, false
+	}
+	q := r.URL.Query()
+	period := defaultStatsPeriod
+	if v := q.Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			api.jsonError(w, http.StatusBadRequest, errors.New("invalid since value"))
+			return time.Time{}, 0, false
+		}
+		period = d
+	}
+	limit := 10
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			api.jsonError(w, http.StatusBadRequest, errors.New("invalid limit value"))
+			return time.Time{}, 0, false
+		}
+		limit = n
+	}
+	return time.Now().Add(-period), limit, true
+}
+
+// StatsServers returns servers with the highest player time.
+func (api *Server) StatsServers(w http.ResponseWriter, r *http.Request) {
+	since, limit, ok := api.statsQuery(w, r)
+	if !ok {
+		return
+	}
+	api.jsonResponse(w, 0, api.hist.TopServers(since, limit))
+}
+
+// StatsMaps returns maps that were played most often.
+func (api *Server) StatsMaps(w http.ResponseWriter, r *http.Request) {
+	since, limit, ok := api.statsQuery(w, r)
+	if !ok {
+		return
+	}
+	api.jsonResponse(w, 0, api.hist.PopularMaps(since, limit))
+}
+
+// StatsPlayers returns a time series of the total number of games and players.
+func (api *Server) StatsPlayers(w http.ResponseWriter, r *http.Request) {
+	since, _, ok := api.statsQuery(w, r)
+	if !ok {
+		return
+	}
+	api.jsonResponse(w, 0, api.hist.PlayerSamples(since))
+}
+
+// StatsSessions returns all game sessions.
+func (api *Server) StatsSessions(w http.ResponseWriter, r *http.Request) {
+	since, _, ok := api.statsQuery(w, r)
+	if !ok {
+		return
+	}
+	api.jsonResponse(w, 0, api.hist.Sessions(since))
+}
@@ -0,0 +1,273 @@
+package lobby
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Storage is a persistent storage for game registrations used by Service.
+type Storage interface {
+	// LoadGames returns all games saved in the storage, including expired ones.
+	LoadGames(ctx context.Context) ([]StoredGame, error)
+	// SaveGame saves or updates the game in the storage.
+	SaveGame(ctx context.Context, g *StoredGame) error
+	// DeleteGame removes the game from the storage.
+	DeleteGame(ctx context.Context, addr string, port int) error
+}
+
+// StoredGame is a game registration saved in the Storage.
+type StoredGame struct {
+	GameInfo
+	// Token is a registration token of the game, if token authentication is enabled. See Service.SetTokenAuth.
+	Token string `json:"token,omitempty"`
+}
+
+var (
+	_ Storage        = (*BoltStorage)(nil)
+	_ HistoryStorage = (*BoltStorage)(nil)
+)
+
+var (
+	boltGamesBucket    = []byte("games")
+	boltSessionsBucket = []byte("sessions")
+	boltSamplesBucket  = []byte("samples")
+)
+
+// OpenBoltStorage opens or creates a BoltDB file which can be used as a Storage.
+func OpenBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltGamesBucket, boltSessionsBucket, boltSamplesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+// BoltStorage is a file-backed Storage and HistoryStorage implementation based on BoltDB.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// Close the storage.
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+func boltGameKey(addr string, port int) []byte {
+	return []byte(net.JoinHostPort(addr, strconv.Itoa(port)))
+}
+
+// LoadGames implements Storage.
+func (s *BoltStorage) LoadGames(ctx context.Context) ([]StoredGame, error) {
+	var out []StoredGame
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltGamesBucket).ForEach(func(k, v []byte) error {
+			var g StoredGame
+			if err := json.Unmarshal(v, &g); err != nil {
+				return err
+			}
+			out = append(out, g)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SaveGame implements Storage.
+func (s *BoltStorage) SaveGame(ctx context.Context, g *StoredGame) error {
+	return s.put(boltGamesBucket, boltGameKey(g.Address, g.Port), g)
+}
+
+// DeleteGame implements Storage.
+func (s *BoltStorage) DeleteGame(ctx context.Context, addr string, port int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltGamesBucket).Delete(boltGameKey(addr, port))
+	})
+}
+
+// boltTimeKey returns a key which is sorted by time.
+func boltTimeKey(t time.Time, suffix string) []byte {
+	key := make([]byte, 8, 8+len(suffix))
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return append(key, suffix...)
+}
+
+func (s *BoltStorage) put(bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, data)
+	})
+}
+
+// SaveSession implements HistoryStorage.
+func (s *BoltStorage) SaveSession(ctx context.Context, v *Session) error {
+	return s.put(boltSessionsBucket, boltTimeKey(v.LastSeen, string(boltGameKey(v.Address, v.Port))), v)
+}
+
+// SaveSample implements HistoryStorage.
+func (s *BoltStorage) SaveSample(ctx context.Context, v *PlayersSample) error {
+	return s.put(boltSamplesBucket, boltTimeKey(v.Time, ""), v)
+}
+
+// LoadHistory implements HistoryStorage.
+func (s *BoltStorage) LoadHistory(ctx context.Context, since time.Time) ([]Session, []PlayersSample, error) {
+	var (
+		sessions []Session
+		samples  []PlayersSample
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		from := boltTimeKey(since, "")
+		c := tx.Bucket(boltSessionsBucket).Cursor()
+		for k, v := c.Seek(from); k != nil; k, v = c.Next() {
+			var sess Session
+			if err := json.Unmarshal(v, &sess); err != nil {
+				return err
+			}
+			sessions = append(sessions, sess)
+		}
+		c = tx.Bucket(boltSamplesBucket).Cursor()
+		for k, v := c.Seek(from); k != nil; k, v = c.Next() {
+			var p PlayersSample
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			samples = append(samples, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return sessions, samples, nil
+}
+
+// PruneHistory implements HistoryStorage.
+func (s *BoltStorage) PruneHistory(ctx context.Context, before time.Time) error {
+	to := boltTimeKey(before, "")
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltSessionsBucket, boltSamplesBucket} {
+			c := tx.Bucket(name).Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, to) < 0; k, _ = c.Next() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// storeRetryDelay is a delay before retrying failed storage writes.
+var storeRetryDelay = time.Second
+
+// storeQueue writes game registrations to the Storage in background, so that Service does not block
+// on disk I/O while holding its lock. Only the latest state of each game is written.
+type storeQueue struct {
+	st Storage
+
+	mu        sync.Mutex
+	pending   map[gameKey]*StoredGame // nil value means the game is deleted
+	scheduled bool
+
+	wmu sync.Mutex // serializes writes
+}
+
+func newStoreQueue(st Storage) *storeQueue {
+	return &storeQueue{st: st, pending: make(map[gameKey]*StoredGame)}
+}
+
+// save the game to the storage. The queue takes the ownership of the game.
+func (q *storeQueue) save(key gameKey, g *StoredGame) {
+	q.enqueue(key, g)
+}
+
+// delete the game from the storage.
+func (q *storeQueue) delete(key gameKey) {
+	q.enqueue(key, nil)
+}
+
+func (q *storeQueue) enqueue(key gameKey, g *StoredGame) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending[key] = g
+	if !q.scheduled {
+		q.scheduled = true
+		go func() {
+			_ = q.flush(context.Background())
+		}()
+	}
+}
+
+// flush writes all pending changes to the storage. It returns the last error, if any.
+func (q *storeQueue) flush(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+	q.wmu.Lock()
+	defer q.wmu.Unlock()
+	q.mu.Lock()
+	batch := q.pending
+	q.pending = make(map[gameKey]*StoredGame)
+	q.scheduled = false
+	q.mu.Unlock()
+	var last error
+	for key, g := range batch {
+		var err error
+		if g == nil {
+			err = q.st.DeleteGame(ctx, key.Addr.String(), key.Port)
+		} else {
+			err = q.st.SaveGame(ctx, g)
+		}
+		if err != nil {
+			log.Printf("cannot write game %s to storage: %v", key, err)
+			last = err
+			q.requeue(key, g)
+		}
+	}
+	return last
+}
+
+// requeue a failed write, unless a newer state of the game is already pending. The write is retried later.
+func (q *storeQueue) requeue(key gameKey, g *StoredGame) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.pending[key]; ok {
+		return
+	}
+	q.pending[key] = g
+	if !q.scheduled {
+		q.scheduled = true
+		time.AfterFunc(storeRetryDelay, func() {
+			_ = q.flush(context.Background())
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package lobby
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBoltStorage(t testing.TB, path string) *BoltStorage {
+	st, err := OpenBoltStorage(path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = st.Close()
+	})
+	return st
+}
+
+// TestLobbyBolt tests lobby implementation backed by BoltDB storage.
+func TestLobbyBolt(t *testing.T) {
+	RunLobbyTests(t, func(t testing.TB) Lobby {
+		st := newTestBoltStorage(t, filepath.Join(t.TempDir(), "lobby.db"))
+		l, err := NewLobbyWithStorage(context.Background(), st)
+		require.NoError(t, err)
+		l.SetTimeout(testTimeout)
+		return l
+	})
+}
+
+func TestLobbyBoltReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "lobby.db")
+
+	st := newTestBoltStorage(t, path)
+	l, err := NewLobbyWithStorage(ctx, st)
+	require.NoError(t, err)
+	// use the default timeout, so that the first game is not removed before the reload
+	registerServer(t, l, initServers[0])
+	time.Sleep(testTimeout * 2)
+	registerServer(t, l, initServers[1])
+	require.NoError(t, l.Flush(ctx))
+	require.NoError(t, st.Close())
+
+	// the first game must expire after the reload, while the second one must be restored
+	st = newTestBoltStorage(t, path)
+	l, err = NewLobbyWithStorage(ctx, st)
+	require.NoError(t, err)
+	l.SetTimeout(testTimeout)
+	expectServers(t, l, initServers[1:2])
+
+	// trigger GC, it must remove the expired game from the storage
+	registerServer(t, l, initServers[1])
+	require.NoError(t, l.Flush(ctx))
+	list, err := st.LoadGames(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, initServers[1], list[0].Game)
+
+	time.Sleep(testTimeout)
+	expectServers(t, l, nil)
+}
+
+// blockingStorage blocks writes until the gate is closed.
+type blockingStorage struct {
+	gate chan struct{}
+	mu   sync.Mutex
+	data map[string]StoredGame
+}
+
+func (s *blockingStorage) LoadGames(ctx context.Context) ([]StoredGame, error) {
+	return nil, nil
+}
+
+func (s *blockingStorage) SaveGame(ctx context.Context, g *StoredGame) error {
+	<-s.gate
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[string(boltGameKey(g.Address, g.Port))] = *g
+	return nil
+}
+
+func (s *blockingStorage) DeleteGame(ctx context.Context, addr string, port int) error {
+	<-s.gate
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, string(boltGameKey(addr, port)))
+	return nil
+}
+
+func TestLobbyStorageWriteBehind(t *testing.T) {
+	ctx := context.Background()
+	st := &blockingStorage{gate: make(chan struct{}), data: make(map[string]StoredGame)}
+	l, err := NewLobbyWithStorage(ctx, st)
+	require.NoError(t, err)
+
+	// storage writes do not block the lobby
+	registerServer(t, l, initServers[0])
+	registerServer(t, l, initServers[1])
+	require.NoError(t, l.UnregisterGame(ctx, initServers[0].Address, 0))
+	expectServers(t, l, initServers[1:2])
+
+	close(st.gate)
+	require.NoError(t, l.Flush(ctx))
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	require.Len(t, st.data, 1)
+	require.Equal(t, initServers[1], st.data["2.2.2.2:18590"].Game)
+}
+
+// flakyStorage fails the first write.
+type flakyStorage struct {
+	mu    sync.Mutex
+	calls int
+	data  map[string]StoredGame
+}
+
+func (s *flakyStorage) LoadGames(ctx context.Context) ([]StoredGame, error) {
+	return nil, nil
+}
+
+func (s *flakyStorage) SaveGame(ctx context.Context, g *StoredGame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls == 1 {
+		return errors.New("disk full")
+	}
+	s.data[string(boltGameKey(g.Address, g.Port))] = *g
+	return nil
+}
+
+func (s *flakyStorage) DeleteGame(ctx context.Context, addr string, port int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, string(boltGameKey(addr, port)))
+	return nil
+}
+
+func TestLobbyStorageRetry(t *testing.T) {
+	ctx := context.Background()
+	st := &flakyStorage{data: make(map[string]StoredGame)}
+	l, err := NewLobbyWithStorage(ctx, st)
+	require.NoError(t, err)
+
+	registerServer(t, l, initServers[0])
+	require.Eventually(t, func() bool {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		return st.calls != 0
+	}, time.Second, testTimeout/10)
+
+	// failed write must not be lost
+	require.NoError(t, l.Flush(ctx))
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	require.Len(t, st.data, 1)
+	require.Equal(t, initServers[0], st.data["1.1.1.1:18590"].Game)
+}
+
+func TestLobbyBoltReloadTokens(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "lobby.db")
+
+	st := newTestBoltStorage(t, path)
+	l, err := NewLobbyWithStorage(ctx, st)
+	require.NoError(t, err)
+	l.SetTokenAuth(true)
+	g1, g2 := initServers[0], initServers[1]
+	tok, err := l.RegisterGameWithToken(ctx, &g1, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, tok)
+	l.SetTokenAuth(false)
+	registerServer(t, l, g2)
+	require.NoError(t, l.Flush(ctx))
+	require.NoError(t, st.Close())
+
+	st = newTestBoltStorage(t, path)
+	l, err = NewLobbyWithStorage(ctx, st)
+	require.NoError(t, err)
+	l.AddHostKey("admin-key")
+	expectServers(t, l, initServers[0:2])
+
+	// token must survive the restart
+	_, err = l.RegisterGameWithToken(ctx, &g1, "")
+	require.ErrorIs(t, err, ErrInvalidToken)
+	require.ErrorIs(t, l.UnregisterGame(ctx, g1.Address, g1.Port), ErrInvalidToken)
+	tok2, err := l.RegisterGameWithToken(ctx, &g1, tok)
+	require.NoError(t, err)
+	require.Equal(t, tok, tok2)
+
+	// game saved without a token can only be updated with a host key
+	_, err = l.RegisterGameWithToken(ctx, &g2, "")
+	require.ErrorIs(t, err, ErrInvalidToken)
+	_, err = l.RegisterGameWithToken(ctx, &g2, "admin-key")
+	require.NoError(t, err)
+}
@@ -0,0 +1,87 @@
+package lobby
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSOptions configures TLS for the lobby server.
+//
+// Either a static certificate (CertFile and KeyFile) or automatic certificates (Domains) must be set.
+type TLSOptions struct {
+	// CertFile and KeyFile set paths to a static certificate and a private key in PEM format.
+	CertFile string
+	KeyFile  string
+
+	// Domains enables automatic certificates from ACME CA (Let's Encrypt by default) for given domains.
+	Domains []string
+	// CacheDir is a directory for storing certificates and ACME account keys.
+	// If not set, certificates are requested again after each restart, which may hit CA rate limits.
+	CacheDir string
+	// Email is an optional contact address for the ACME account.
+	Email string
+	// DirectoryURL is an ACME directory URL. If not set, Let's Encrypt is used.
+	DirectoryURL string
+	// HTTPClient is used for ACME requests. It can be set to trust a certificate of a local ACME server.
+	HTTPClient *http.Client
+}
+
+// TLSConfig creates TLS config from the options.
+//
+// It also returns a handler for the plain HTTP listener, which redirects all requests to HTTPS port.
+// For automatic certificates, the same handler answers ACME HTTP challenges, thus it must be served on port 80.
+func (o *TLSOptions) TLSConfig(httpsPort string) (*tls.Config, http.Handler, error) {
+	redirect := RedirectHTTPS(httpsPort)
+	if len(o.Domains) == 0 {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, nil, errors.New("either TLS domains or certificate and key must be set")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, redirect, nil
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		return nil, nil, errors.New("TLS domains cannot be used together with a static certificate")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(o.Domains...),
+		Email:      o.Email,
+		Client: &acme.Client{
+			DirectoryURL: o.DirectoryURL,
+			HTTPClient:   o.HTTPClient,
+			UserAgent:    "nox-lobby",
+		},
+	}
+	if o.CacheDir != "" {
+		m.Cache = autocert.DirCache(o.CacheDir)
+	}
+	h := m.HTTPHandler(redirect)
+	return m.TLSConfig(), h, nil
+}
+
+// RedirectHTTPS returns a handler that redirects all requests to HTTPS on a given port.
+// If port is empty, the default HTTPS port is used.
+//
+// Permanent redirect (308) is used, so that clients repeat the request with the same method and body.
+func RedirectHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,333 @@
+package lobby
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testTLSDomain = "lobby.example.com"
+
+// testCA is a certificate authority for tests.
+type testCA struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestCA(t testing.TB) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{key: key, cert: cert}
+}
+
+func (ca *testCA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func (ca *testCA) Issue(t testing.TB, pub interface{}, domains []string) []byte {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, pub, ca.key)
+	require.NoError(t, err)
+	return der
+}
+
+// testACME is a minimal ACME server for tests. It does not verify request signatures,
+// but validates HTTP challenges by requesting them from a given address.
+type testACME struct {
+	t        testing.TB
+	ca       *testCA
+	srv      *httptest.Server
+	httpAddr string
+
+	mu      sync.Mutex
+	domains []string
+	valid   bool
+	cert    []byte
+}
+
+func newTestACME(t testing.TB, ca *testCA, httpAddr string) *testACME {
+	s := &testACME{t: t, ca: ca, httpAddr: httpAddr}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+func (s *testACME) DirectoryURL() string {
+	return s.srv.URL + "/dir"
+}
+
+func (s *testACME) order() map[string]interface{} {
+	o := map[string]interface{}{
+		"status":         "pending",
+		"authorizations": []string{s.srv.URL + "/authz"},
+		"finalize":       s.srv.URL + "/finalize",
+	}
+	if s.valid {
+		o["status"] = "ready"
+	}
+	if s.cert != nil {
+		o["status"] = "valid"
+		o["certificate"] = s.srv.URL + "/cert"
+	}
+	return o
+}
+
+func (s *testACME) challenge() map[string]interface{} {
+	c := map[string]interface{}{
+		"type": "http-01", "url": s.srv.URL + "/chal", "token": "test-token", "status": "pending",
+	}
+	if s.valid {
+		c["status"] = "valid"
+	}
+	return c
+}
+
+func (s *testACME) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", base64.RawURLEncoding.EncodeToString([]byte(time.Now().String())))
+	var payload []byte
+	if r.Method == http.MethodPost {
+		var req struct {
+			Payload string `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload, _ = base64.RawURLEncoding.DecodeString(req.Payload)
+	}
+	reply := func(code int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.URL.Path {
+	case "/dir":
+		reply(http.StatusOK, map[string]string{
+			"newNonce":   s.srv.URL + "/nonce",
+			"newAccount": s.srv.URL + "/account",
+			"newOrder":   s.srv.URL + "/order",
+			"revokeCert": s.srv.URL + "/revoke",
+			"keyChange":  s.srv.URL + "/key",
+		})
+	case "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case "/account":
+		w.Header().Set("Location", s.srv.URL+"/account/1")
+		reply(http.StatusCreated, map[string]string{"status": "valid"})
+	case "/order":
+		var req struct {
+			Identifiers []struct {
+				Value string `json:"value"`
+			} `json:"identifiers"`
+		}
+		_ = json.Unmarshal(payload, &req)
+		s.domains = nil
+		for _, id := range req.Identifiers {
+			s.domains = append(s.domains, id.Value)
+		}
+		w.Header().Set("Location", s.srv.URL+"/order/1")
+		reply(http.StatusCreated, s.order())
+	case "/order/1":
+		reply(http.StatusOK, s.order())
+	case "/authz":
+		z := map[string]interface{}{
+			"status":     "pending",
+			"identifier": map[string]string{"type": "dns", "value": s.domains[0]},
+			"challenges": []interface{}{s.challenge()},
+		}
+		if s.valid {
+			z["status"] = "valid"
+		}
+		reply(http.StatusOK, z)
+	case "/chal":
+		s.valid = s.validate()
+		reply(http.StatusOK, s.challenge())
+	case "/finalize":
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		_ = json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || !s.valid {
+			reply(http.StatusForbidden, map[string]string{"type": "urn:ietf:params:acme:error:unauthorized"})
+			return
+		}
+		s.cert = s.ca.Issue(s.t, csr.PublicKey, csr.DNSNames)
+		reply(http.StatusOK, s.order())
+	case "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: s.cert})
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: s.ca.cert.Raw})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// validate checks the HTTP challenge response.
+func (s *testACME) validate() bool {
+	req, err := http.NewRequest(http.MethodGet, "http://"+s.httpAddr+"/.well-known/acme-challenge/test-token", nil)
+	if err != nil {
+		return false
+	}
+	req.Host = s.domains[0]
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode == http.StatusOK && strings.HasPrefix(string(data), "test-token.")
+}
+
+// serveTestTLS starts TLS and plain HTTP servers for the lobby API.
+func serveTestTLS(t testing.TB, conf *tls.Config, redirect http.Handler, httpLn net.Listener) string {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(NewServer(nil))
+	srv.TLS = conf
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	hsrv := &http.Server{Handler: redirect}
+	go hsrv.Serve(httpLn)
+	t.Cleanup(func() { _ = hsrv.Close() })
+	return srv.Listener.Addr().String()
+}
+
+func testTLSClient(ca *testCA) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: ca.Pool(), ServerName: testTLSDomain},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func TestTLSACME(t *testing.T) {
+	httpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ca := newTestCA(t)
+	acmeSrv := newTestACME(t, ca, httpLn.Addr().String())
+
+	dir := t.TempDir()
+	opts := &TLSOptions{
+		Domains:      []string{testTLSDomain},
+		CacheDir:     dir,
+		DirectoryURL: acmeSrv.DirectoryURL(),
+	}
+	conf, redirect, err := opts.TLSConfig("8443")
+	require.NoError(t, err)
+	addr := serveTestTLS(t, conf, redirect, httpLn)
+
+	cli := testTLSClient(ca)
+	resp, err := cli.Get("https://" + addr + "/api/v0/address")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []string{testTLSDomain}, resp.TLS.PeerCertificates[0].DNSNames)
+
+	// certificate is cached
+	_, err = os.Stat(filepath.Join(dir, testTLSDomain))
+	require.NoError(t, err)
+
+	// plain HTTP requests are redirected
+	req, err := http.NewRequest(http.MethodGet, "http://"+httpLn.Addr().String()+"/api/v0/games/list?mode=ctf", nil)
+	require.NoError(t, err)
+	req.Host = testTLSDomain
+	resp, err = cli.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusPermanentRedirect, resp.StatusCode)
+	require.Equal(t, "https://"+testTLSDomain+":8443/api/v0/games/list?mode=ctf", resp.Header.Get("Location"))
+}
+
+func TestTLSStatic(t *testing.T) {
+	ca := newTestCA(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der := ca.Issue(t, &key.PublicKey, []string{testTLSDomain})
+	kder, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	require.NoError(t, err)
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600)
+	require.NoError(t, err)
+
+	_, _, err = (&TLSOptions{CertFile: certFile}).TLSConfig("")
+	require.Error(t, err)
+	_, _, err = (&TLSOptions{CertFile: certFile, KeyFile: keyFile, Domains: []string{testTLSDomain}}).TLSConfig("")
+	require.Error(t, err)
+
+	conf, redirect, err := (&TLSOptions{CertFile: certFile, KeyFile: keyFile}).TLSConfig("")
+	require.NoError(t, err)
+	httpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := serveTestTLS(t, conf, redirect, httpLn)
+
+	cli := testTLSClient(ca)
+	resp, err := cli.Get("https://" + addr + "/api/v0/address")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = cli.Get("http://" + httpLn.Addr().String() + "/api/v0/address")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusPermanentRedirect, resp.StatusCode)
+	require.Equal(t, "https://127.0.0.1/api/v0/address", resp.Header.Get("Location"))
+
+	// other methods are redirected as well
+	resp, err = cli.Post("http://"+httpLn.Addr().String()+"/api/v0/games/register", "application/json", strings.NewReader("{}"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusPermanentRedirect, resp.StatusCode)
+	require.Equal(t, "https://127.0.0.1/api/v0/games/register", resp.Header.Get("Location"))
+}
@@ -0,0 +1,93 @@
+package lobby
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrInvalidToken is returned when a game registration is protected by a token and the token is missing or invalid.
+var ErrInvalidToken = errors.New("invalid registration token")
+
+// TokenRegisterer is a Registerer that protects game registrations with per-host tokens.
+type TokenRegisterer interface {
+	// RegisterGameWithToken registers a game, similar to Registerer.RegisterGame.
+	//
+	// Token must be set to a value returned by the previous call for the same game, or to a host key issued by
+	// the lobby administrator. Any token (including an empty one) is accepted for the first registration.
+	//
+	// It returns a token that must be used for the following registrations of the same game.
+	// An empty token is returned if the lobby does not require tokens.
+	RegisterGameWithToken(ctx context.Context, s *Game, token string) (string, error)
+}
+
+// TokenUnregisterer is an Unregisterer that protects game registrations with per-host tokens.
+type TokenUnregisterer interface {
+	// UnregisterGameWithToken removes the game registration, similar to Unregisterer.UnregisterGame.
+	// Token must be set to a value returned by TokenRegisterer.RegisterGameWithToken for this game.
+	UnregisterGameWithToken(ctx context.Context, addr string, port int, token string) error
+}
+
+// newToken generates a new random registration token.
+func newToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+func tokenEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// SetTokenAuth enables or disables registration tokens.
+//
+// When enabled, the first registration of the game returns a token, which must be presented
+// on all following registrations until the game expires. See TokenRegisterer.
+//
+// Tokens are persisted to the Storage together with games, thus registrations restored after a restart
+// are still protected. Games restored without a token can only be updated with a host key until they expire.
+func (l *Service) SetTokenAuth(enabled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokenAuth = enabled
+}
+
+// AddHostKey adds a host key issued by the lobby administrator. Host keys can be used as registration tokens
+// for any game and enable token authentication automatically.
+func (l *Service) AddHostKey(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokenAuth = true
+	if l.hostKeys == nil {
+		l.hostKeys = make(map[string]struct{})
+	}
+	l.hostKeys[key] = struct{}{}
+}
+
+// checkToken checks the token for a given game and returns the token for the following registrations.
+// It must be called with the lock held.
+func (l *Service) checkToken(key gameKey, prev *GameInfo, token string) (string, error) {
+	if !l.tokenAuth {
+		return "", nil
+	}
+	_, isHostKey := l.hostKeys[token]
+	if cur, ok := l.tokens[key]; ok && prev != nil {
+		if cur == "" && isHostKey {
+			// restored from the storage without a token
+			return token, nil
+		}
+		// game is registered - token must match
+		if token == "" || !tokenEqual(cur, token) {
+			return "", ErrInvalidToken
+		}
+		return cur, nil
+	}
+	if isHostKey {
+		return token, nil
+	}
+	return newToken()
+}
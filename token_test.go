@@ -0,0 +1,159 @@
+package lobby
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceTokens(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	l.SetTimeout(testTimeout)
+	l.SetTokenAuth(true)
+
+	g := server1
+	tok, err := l.RegisterGameWithToken(ctx, &g, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, tok)
+
+	// cannot update without a token, or with a wrong one
+	g2 := g
+	g2.Name = "hijack"
+	_, err = l.RegisterGameWithToken(ctx, &g2, "")
+	require.ErrorIs(t, err, ErrInvalidToken)
+	_, err = l.RegisterGameWithToken(ctx, &g2, "wrong")
+	require.ErrorIs(t, err, ErrInvalidToken)
+	require.ErrorIs(t, l.RegisterGame(ctx, &g2), ErrInvalidToken)
+	expectServers(t, l, []Game{server1})
+
+	tok2, err := l.RegisterGameWithToken(ctx, &g, tok)
+	require.NoError(t, err)
+	require.Equal(t, tok, tok2)
+
+	// token is released when the game expires
+	time.Sleep(testTimeout * 2)
+	tok3, err := l.RegisterGameWithToken(ctx, &g2, "")
+	require.NoError(t, err)
+	require.NotEqual(t, tok, tok3)
+	expectServers(t, l, []Game{g2})
+}
+
+func TestServiceTokensBan(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	l.SetTokenAuth(true)
+	bans := NewBanList()
+	_, err := bans.AddBan(Ban{Kind: BanName, Value: "(?i)spam", Mute: true})
+	require.NoError(t, err)
+	l.SetBanList(bans)
+
+	g := server1
+	tok, err := l.RegisterGameWithToken(ctx, &g, "")
+	require.NoError(t, err)
+
+	// banned registration must not remove the game without a token
+	g2 := g
+	g2.Name = "spam"
+	_, err = l.RegisterGameWithToken(ctx, &g2, "")
+	require.NoError(t, err)
+	expectServers(t, l, []Game{server1})
+
+	// game is removed once it matches the ban itself
+	_, err = bans.AddBan(Ban{Kind: BanAddr, Value: server1.Address})
+	require.NoError(t, err)
+	_, err = l.RegisterGameWithToken(ctx, &g, tok)
+	require.ErrorIs(t, err, ErrBanned)
+	require.Empty(t, l.Registrations())
+}
+
+func TestServiceHostKey(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	l.AddHostKey("admin-key")
+
+	g := server1
+	tok, err := l.RegisterGameWithToken(ctx, &g, "admin-key")
+	require.NoError(t, err)
+	require.Equal(t, "admin-key", tok)
+
+	_, err = l.RegisterGameWithToken(ctx, &g, "")
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = l.RegisterGameWithToken(ctx, &g, "admin-key")
+	require.NoError(t, err)
+}
+
+func TestClientTokens(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	l.SetTokenAuth(true)
+	api := NewServer(l)
+	api.trustAddr = true
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	c1 := NewClient(srv.URL)
+	g := server1
+	require.NoError(t, c1.RegisterGame(ctx, &g))
+	// token must be reused automatically
+	g.Players.Cur = 1
+	require.NoError(t, c1.RegisterGame(ctx, &g))
+
+	c2 := NewClient(srv.URL)
+	g2 := g
+	g2.Name = "hijack"
+	err := c2.RegisterGame(ctx, &g2)
+	require.Error(t, err)
+	err = c2.UnregisterGame(ctx, g.Address, g.Port)
+	require.Error(t, err)
+	expectServers(t, l, []Game{g})
+
+	err = c1.UnregisterGame(ctx, g.Address, g.Port)
+	require.NoError(t, err)
+	expectServers(t, l, nil)
+}
+
+func TestClientTokensFailover(t *testing.T) {
+	ctx := context.Background()
+	newServer := func() (*Service, *flakyServer, string) {
+		l := NewLobby()
+		l.SetTokenAuth(true)
+		api := NewServer(l)
+		api.trustAddr = true
+		fs := &flakyServer{h: api, status: http.StatusBadGateway}
+		srv := httptest.NewServer(fs)
+		t.Cleanup(srv.Close)
+		return l, fs, srv.URL
+	}
+	l1, fs1, url1 := newServer()
+	l2, fs2, url2 := newServer()
+	c := NewClient(url1)
+	c.SetServerURLs(url1, url2)
+	p := testRetryPolicy
+	p.MaxAttempts = 2
+	c.SetRetryPolicy(p)
+
+	g := server1
+	require.NoError(t, c.RegisterGame(ctx, &g))
+	expectServers(t, l1, []Game{g})
+
+	// fail over to the second server, it issues its own token
+	atomic.StoreInt32(&fs1.fail, 1000)
+	require.NoError(t, c.RegisterGame(ctx, &g))
+	expectServers(t, l2, []Game{g})
+
+	// fail back, the token of the first server must still be used
+	atomic.StoreInt32(&fs1.fail, 0)
+	atomic.StoreInt32(&fs1.calls, 0)
+	atomic.StoreInt32(&fs2.fail, 1000)
+	require.NoError(t, c.RegisterGame(ctx, &g))
+
+	require.NoError(t, c.UnregisterGame(ctx, g.Address, g.Port))
+	expectServers(t, l1, nil)
+}
@@ -0,0 +1,195 @@
+package lobby
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits for game fields, see Game.Validate.
+const (
+	// MaxNameLen is a max length of the game name, in characters.
+	MaxNameLen = 64
+	// MaxMapLen is a max length of the map name, in characters.
+	MaxMapLen = 32
+	// MaxVersLen is a max length of the game version, in characters.
+	MaxVersLen = 32
+	// MaxPlayerNameLen is a max length of the player name, in characters.
+	MaxPlayerNameLen = 32
+	// MaxPlayerClassLen is a max length of the player class, in characters.
+	MaxPlayerClassLen = 16
+	// MaxPlayers is a max number of players in a single game.
+	MaxPlayers = 128
+	// MaxResolution is a max width or height of the game resolution.
+	MaxResolution = 8192
+)
+
+// ErrInvalidGame is returned when the game information is invalid. See ValidationError for details.
+var ErrInvalidGame = errors.New("invalid game")
+
+// ValidationError describes an invalid field of the Game. It matches ErrInvalidGame with errors.Is.
+type ValidationError struct {
+	// Field is a name of the invalid field, as encoded in JSON. For example, "players.list[1].name".
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid game: %s: %s", e.Field, e.Reason)
+}
+
+// Is implements errors.Is.
+func (e *ValidationError) Is(err error) bool {
+	return err == ErrInvalidGame
+}
+
+func invalidField(field, reason string) error {
+	return &ValidationError{Field: field, Reason: reason}
+}
+
+// knownModes is a set of game modes known to the lobby.
+var knownModes = map[GameMode]struct{}{
+	ModeKOTR: {}, ModeCTF: {}, ModeFlagBall: {}, ModeChat: {}, ModeArena: {},
+	ModeElimination: {}, ModeQuest: {}, ModeCoop: {}, ModeCustom: {},
+}
+
+// validateName checks a name of the game or the player. Names must be valid UTF-8 strings with printable
+// characters only (as defined by unicode.IsPrint), and must not start or end with spaces.
+func validateName(field, s string, max int) error {
+	if s == "" {
+		return invalidField(field, "must be set")
+	}
+	if !utf8.ValidString(s) {
+		return invalidField(field, "invalid UTF-8")
+	}
+	if utf8.RuneCountInString(s) > max {
+		return invalidField(field, fmt.Sprintf("longer than %d characters", max))
+	}
+	if s != strings.TrimSpace(s) {
+		return invalidField(field, "must not start or end with spaces")
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return invalidField(field, fmt.Sprintf("invalid character %q", r))
+		}
+	}
+	return nil
+}
+
+// validateToken checks an ASCII identifier, like the map name or the game version.
+func validateToken(field, s string, max int, allowed func(r rune) bool) error {
+	if s == "" {
+		return invalidField(field, "must be set")
+	}
+	if len(s) > max {
+		return invalidField(field, fmt.Sprintf("longer than %d characters", max))
+	}
+	for _, r := range s {
+		if r >= utf8.RuneSelf || !allowed(r) {
+			return invalidField(field, fmt.Sprintf("invalid character %q", r))
+		}
+	}
+	return nil
+}
+
+func isMapChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-'
+}
+
+func isVersChar(r rune) bool {
+	return r > ' ' && r < utf8.RuneSelf && unicode.IsPrint(r)
+}
+
+// Normalize converts fields of the game to the canonical form: the map name is converted to lower case,
+// and unknown game modes are replaced with ModeCustom. The lobby normalizes games on registration.
+func (g *Game) Normalize() {
+	g.Map = strings.ToLower(g.Map)
+	if _, ok := knownModes[g.Mode]; !ok && g.Mode != "" {
+		g.Mode = ModeCustom
+	}
+}
+
+// Validate checks the game information and returns ValidationError for the first invalid field.
+// The game is not modified, see Normalize.
+//
+// Limits are the following:
+//   - Name: required, up to MaxNameLen printable Unicode characters (see unicode.IsPrint),
+//     no leading or trailing spaces.
+//   - Address and AltAddress: optional, must be valid IP addresses of different families.
+//   - Port: optional, a valid UDP port.
+//   - Map: required, up to MaxMapLen ASCII letters, digits, '_' or '-'. Letters are case-insensitive.
+//   - Mode: required, unknown modes are allowed. Access: optional, must be one of the known values.
+//   - Vers: required, up to MaxVersLen printable ASCII characters without spaces.
+//   - Res: width and height between 0 and MaxResolution.
+//   - Players: Max between 1 and MaxPlayers, Cur between 0 and Max; List must not have more than Cur entries.
+//     Player names follow the same rules as the game name, up to MaxPlayerNameLen characters.
+//   - Quest: stage must not be negative.
+func (g *Game) Validate() error {
+	if err := validateName("name", g.Name, MaxNameLen); err != nil {
+		return err
+	}
+	if g.Address != "" {
+		tmp := Game{Address: g.Address, AltAddress: g.AltAddress}
+		if err := tmp.canonicalAddrs(); err != nil {
+			return invalidField("addr", err.Error())
+		}
+	} else if g.AltAddress != "" {
+		if _, err := parseAddr(g.AltAddress); err != nil {
+			return invalidField("addr_alt", err.Error())
+		}
+	}
+	if g.Port < 0 || g.Port > 0xffff {
+		return invalidField("port", "invalid port")
+	}
+	if err := validateToken("map", strings.ToLower(g.Map), MaxMapLen, isMapChar); err != nil {
+		return err
+	}
+	if g.Mode == "" {
+		return invalidField("mode", "must be set")
+	}
+	switch g.Access {
+	case "", AccessOpen, AccessPassword, AccessClosed:
+	default:
+		return invalidField("access", fmt.Sprintf("unknown value %q", g.Access))
+	}
+	if err := validateToken("vers", g.Vers, MaxVersLen, isVersChar); err != nil {
+		return err
+	}
+	if g.Res.Width < 0 || g.Res.Width > MaxResolution {
+		return invalidField("res.width", "out of range")
+	}
+	if g.Res.Height < 0 || g.Res.Height > MaxResolution {
+		return invalidField("res.height", "out of range")
+	}
+	if g.Players.Max <= 0 {
+		return invalidField("players.max", "must be set")
+	}
+	if g.Players.Max > MaxPlayers {
+		return invalidField("players.max", fmt.Sprintf("more than %d", MaxPlayers))
+	}
+	if g.Players.Cur < 0 {
+		return invalidField("players.cur", "must not be negative")
+	}
+	if g.Players.Cur > g.Players.Max {
+		return invalidField("players.cur", "more than max players")
+	}
+	if len(g.Players.List) > g.Players.Cur {
+		return invalidField("players.list", "more entries than current players")
+	}
+	for i, p := range g.Players.List {
+		if err := validateName(fmt.Sprintf("players.list[%d].name", i), p.Name, MaxPlayerNameLen); err != nil {
+			return err
+		}
+		if p.Class != "" {
+			if err := validateName(fmt.Sprintf("players.list[%d].class", i), p.Class, MaxPlayerClassLen); err != nil {
+				return err
+			}
+		}
+	}
+	if g.Quest != nil && g.Quest.Stage < 0 {
+		return invalidField("quest.stage", "must not be negative")
+	}
+	return nil
+}
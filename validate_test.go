@@ -0,0 +1,88 @@
+package lobby
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGameValidate(t *testing.T) {
+	g := server1.Clone()
+	g.Map = "Estate"
+	g.Mode = "unknown"
+	require.NoError(t, g.Validate())
+	// validation must not modify the game
+	require.Equal(t, "Estate", g.Map)
+	require.Equal(t, GameMode("unknown"), g.Mode)
+	g.Normalize()
+	require.Equal(t, "estate", g.Map)
+	require.Equal(t, ModeCustom, g.Mode)
+
+	for _, c := range []struct {
+		field string
+		edit  func(g *Game)
+	}{
+		{"name", func(g *Game) { g.Name = "" }},
+		{"name", func(g *Game) { g.Name = " spaces " }},
+		{"name", func(g *Game) { g.Name = "bad\x00name" }},
+		{"name", func(g *Game) { g.Name = "\xff" }},
+		{"name", func(g *Game) { g.Name = strings.Repeat("a", MaxNameLen+1) }},
+		{"addr", func(g *Game) { g.Address = "bad" }},
+		{"addr", func(g *Game) { g.Address, g.AltAddress = "127.0.0.1", "127.0.0.2" }},
+		{"addr_alt", func(g *Game) { g.Address, g.AltAddress = "", "bad" }},
+		{"port", func(g *Game) { g.Port = 70000 }},
+		{"map", func(g *Game) { g.Map = "" }},
+		{"map", func(g *Game) { g.Map = "../map" }},
+		{"map", func(g *Game) { g.Map = strings.Repeat("a", MaxMapLen+1) }},
+		{"mode", func(g *Game) { g.Mode = "" }},
+		{"access", func(g *Game) { g.Access = "secret" }},
+		{"vers", func(g *Game) { g.Vers = "1 0" }},
+		{"res.width", func(g *Game) { g.Res.Width = -1 }},
+		{"res.height", func(g *Game) { g.Res.Height = MaxResolution + 1 }},
+		{"players.max", func(g *Game) { g.Players.Max = 0 }},
+		{"players.max", func(g *Game) { g.Players.Max = MaxPlayers + 1 }},
+		{"players.cur", func(g *Game) { g.Players.Cur = -1 }},
+		{"players.cur", func(g *Game) { g.Players.Cur = g.Players.Max + 1 }},
+		{"players.list", func(g *Game) { g.Players.Cur, g.Players.List = 0, []PlayerInfo{{Name: "bob"}} }},
+		{"players.list[1].name", func(g *Game) {
+			g.Players.Cur, g.Players.List = 2, []PlayerInfo{{Name: "bob"}, {Name: ""}}
+		}},
+		{"players.list[0].class", func(g *Game) {
+			g.Players.Cur, g.Players.List = 1, []PlayerInfo{{Name: "bob", Class: strings.Repeat("a", MaxPlayerClassLen+1)}}
+		}},
+		{"quest.stage", func(g *Game) { g.Quest = &QuestInfo{Stage: -1} }},
+	} {
+		t.Run(c.field, func(t *testing.T) {
+			g := server1.Clone()
+			c.edit(g)
+			err := g.Validate()
+			require.ErrorIs(t, err, ErrInvalidGame)
+			var verr *ValidationError
+			require.True(t, errors.As(err, &verr))
+			require.Equal(t, c.field, verr.Field)
+		})
+	}
+}
+
+func TestValidateHTTP(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	srv := httptest.NewServer(NewServer(l))
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	// client rejects invalid games before sending
+	g := server1.Clone()
+	g.Players.Cur = g.Players.Max + 1
+	err := c.RegisterGame(ctx, g)
+	require.ErrorIs(t, err, ErrInvalidGame)
+
+	// server rejects them as well
+	err = c.sendRequest(ctx, "POST", "/api/v0/games/register", g, nil)
+	require.Error(t, err)
+	expectServers(t, l, nil)
+}
@@ -0,0 +1,259 @@
+package lobby
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWatchInterval is a default interval for polling game lists that do not support watching natively.
+	DefaultWatchInterval = 5 * time.Second
+)
+
+// EventType is a type of the change in the game list.
+type EventType string
+
+const (
+	EventAdd    = EventType("add")
+	EventUpdate = EventType("update")
+	EventRemove = EventType("remove")
+)
+
+// GameEvent describes a single change in the game list.
+type GameEvent struct {
+	Type EventType `json:"type"`
+	Game GameInfo  `json:"game"`
+}
+
+// Watcher is an interface for watching changes in the game list.
+type Watcher interface {
+	// WatchGames returns a channel with game list changes.
+	// First events on the channel always describe the current game list (as EventAdd).
+	//
+	// The channel is closed when the context is canceled, or if the consumer cannot keep up with the changes.
+	WatchGames(ctx context.Context) (<-chan GameEvent, error)
+}
+
+// watchRetryDelay is a delay before subscribing to the Watcher again, see runWatcher.
+const watchRetryDelay = time.Second
+
+// runWatcher subscribes to the Watcher and passes events to a given function, until the context is canceled.
+// The Watcher is subscribed again if the subscription fails, or if the function returns (usually when the channel
+// is closed). Since the initial events describe all current games, consumers should reset their state on each call.
+// Name is used as a prefix for logs.
+func runWatcher(ctx context.Context, name string, w Watcher, consume func(events <-chan GameEvent)) error {
+	for {
+		if events, err := w.WatchGames(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("%s: cannot watch games: %v", name, err)
+		} else {
+			consume(events)
+		}
+		// subscribe again
+		if err := sleepCtx(ctx, watchRetryDelay); err != nil {
+			return err
+		}
+	}
+}
+
+// watchBuffer is a buffer size for event channels. Subscribers that fall behind by this number of events are dropped.
+const watchBuffer = 64
+
+// broadcaster distributes game events to all subscribers.
+type broadcaster struct {
+	mu   sync.Mutex
+	subs map[chan GameEvent]struct{}
+}
+
+// subscribe adds a new subscriber. Initial events are sent to the subscriber before any other events.
+func (b *broadcaster) subscribe(ctx context.Context, initial []GameEvent) <-chan GameEvent {
+	in := make(chan GameEvent, watchBuffer)
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = make(map[chan GameEvent]struct{})
+	}
+	b.subs[in] = struct{}{}
+	b.mu.Unlock()
+	out := make(chan GameEvent)
+	go func() {
+		defer close(out)
+		defer b.unsubscribe(in)
+		for _, ev := range initial {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- ev:
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case out <- ev:
+				}
+			}
+		}
+	}()
+	return out
+}
+
+func (b *broadcaster) unsubscribe(ch chan GameEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// publish sends an event to all subscribers. It never blocks.
+func (b *broadcaster) publish(ev GameEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			// subscriber cannot keep up - drop it
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// gameEvent returns an event for a game update. It returns false if the game was not changed.
+func gameEvent(prev, cur *GameInfo) (GameEvent, bool) {
+	if prev == nil {
+		return GameEvent{Type: EventAdd, Game: *cur.Clone()}, true
+	}
+	if reflect.DeepEqual(prev.Game, cur.Game) {
+		return GameEvent{}, false
+	}
+	return GameEvent{Type: EventUpdate, Game: *cur.Clone()}, true
+}
+
+// PollWatcher creates a Watcher which periodically lists games from a Lister and reports the difference.
+// If interval is zero, DefaultWatchInterval is used.
+//
+// The list is polled only while there are active subscribers, and all subscribers share the same poller.
+func PollWatcher(l Lister, interval time.Duration) Watcher {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	return &pollWatcher{l: l, interval: interval}
+}
+
+type pollWatcher struct {
+	l        Lister
+	interval time.Duration
+	events   broadcaster
+
+	mu   sync.Mutex
+	subs int
+	cur  map[gameKey]GameInfo // nil if the poller is not running
+	stop func()
+}
+
+// diffGames compares the new list with the previous one and returns events for the changes.
+func diffGames(prev map[gameKey]GameInfo, list []GameInfo) (map[gameKey]GameInfo, []GameEvent) {
+	var events []GameEvent
+	cur := make(map[gameKey]GameInfo, len(list))
+	for _, g := range list {
+		g := g
+		key := g.gameKey()
+		cur[key] = g
+		var old *GameInfo
+		if g2, ok := prev[key]; ok {
+			old = &g2
+		}
+		if ev, ok := gameEvent(old, &g); ok {
+			events = append(events, ev)
+		}
+	}
+	for key, g := range prev {
+		if _, ok := cur[key]; !ok {
+			events = append(events, GameEvent{Type: EventRemove, Game: g})
+		}
+	}
+	return cur, events
+}
+
+// WatchGames implements Watcher.
+func (w *pollWatcher) WatchGames(ctx context.Context) (<-chan GameEvent, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cur == nil {
+		list, err := w.l.ListGames(ctx)
+		if err != nil {
+			return nil, err
+		}
+		w.cur, _ = diffGames(nil, list)
+		pctx, cancel := context.WithCancel(context.Background())
+		w.stop = cancel
+		go w.poll(pctx)
+	}
+	initial := make([]GameEvent, 0, len(w.cur))
+	for _, g := range w.cur {
+		initial = append(initial, GameEvent{Type: EventAdd, Game: *g.Clone()})
+	}
+	sort.Slice(initial, func(i, j int) bool {
+		return gameKeyLess(initial[i].Game.gameKey(), initial[j].Game.gameKey())
+	})
+	ch := w.events.subscribe(ctx, initial)
+	w.subs++
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.subs--
+		if w.subs == 0 {
+			w.stop()
+			w.cur, w.stop = nil, nil
+		}
+	}()
+	return ch, nil
+}
+
+// poll the game list and publish changes until the context is canceled.
+func (w *pollWatcher) poll(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		list, err := w.l.ListGames(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("watch: cannot list games: %v", err)
+			}
+			continue
+		}
+		w.mu.Lock()
+		if ctx.Err() != nil {
+			w.mu.Unlock()
+			return
+		}
+		var events []GameEvent
+		w.cur, events = diffGames(w.cur, list)
+		for _, ev := range events {
+			w.events.publish(ev)
+		}
+		w.mu.Unlock()
+	}
+}
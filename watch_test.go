@@ -0,0 +1,149 @@
+package lobby
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func expectEvent(t testing.TB, ch <-chan GameEvent, typ EventType, g Game) {
+	select {
+	case ev, ok := <-ch:
+		require.True(t, ok, "channel closed")
+		require.Equal(t, typ, ev.Type)
+		require.Equal(t, g, ev.Game.Game)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+}
+
+func testWatch(t *testing.T, l Lobby, w Watcher) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registerServer(t, l, initServers[0])
+	ch, err := w.WatchGames(ctx)
+	require.NoError(t, err)
+	expectEvent(t, ch, EventAdd, initServers[0])
+
+	registerServer(t, l, initServers[1])
+	expectEvent(t, ch, EventAdd, initServers[1])
+
+	g := initServers[1]
+	g.Players.Cur = 2
+	registerServer(t, l, g)
+	expectEvent(t, ch, EventUpdate, g)
+
+	// both games must expire
+	got := make(map[string]EventType)
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-ch:
+			got[ev.Game.Name] = ev.Type
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+	}
+	require.Equal(t, map[string]EventType{
+		initServers[0].Name: EventRemove,
+		initServers[1].Name: EventRemove,
+	}, got)
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestServiceWatch(t *testing.T) {
+	l := NewLobby()
+	l.SetTimeout(testTimeout * 3)
+	testWatch(t, l, l)
+}
+
+func TestPollWatch(t *testing.T) {
+	l := NewLobby()
+	l.SetTimeout(testTimeout * 3)
+	testWatch(t, l, PollWatcher(l, testTimeout/3))
+}
+
+func TestClientWatch(t *testing.T) {
+	l := NewLobby()
+	l.SetTimeout(testTimeout * 3)
+	srv := httptest.NewServer(NewServer(l))
+	defer srv.Close()
+	testWatch(t, l, NewClient(srv.URL))
+}
+
+func TestPollWatchShared(t *testing.T) {
+	ctx := context.Background()
+	l := NewLobby()
+	registerServer(t, l, initServers[0])
+	var calls int32
+	w := PollWatcher(listerFunc(func(ctx context.Context) ([]GameInfo, error) {
+		atomic.AddInt32(&calls, 1)
+		return l.ListGames(ctx)
+	}), testTimeout)
+
+	ctx1, cancel1 := context.WithCancel(ctx)
+	defer cancel1()
+	ch1, err := w.WatchGames(ctx1)
+	require.NoError(t, err)
+	expectEvent(t, ch1, EventAdd, initServers[0])
+	ctx2, cancel2 := context.WithCancel(ctx)
+	defer cancel2()
+	ch2, err := w.WatchGames(ctx2)
+	require.NoError(t, err)
+	expectEvent(t, ch2, EventAdd, initServers[0])
+
+	// both subscribers receive events from the same poller
+	registerServer(t, l, initServers[1])
+	expectEvent(t, ch1, EventAdd, initServers[1])
+	expectEvent(t, ch2, EventAdd, initServers[1])
+	time.Sleep(testTimeout * 5)
+	n := atomic.LoadInt32(&calls)
+	require.True(t, n >= 5 && n <= 8, "calls: %d", n)
+
+	// poller stops when there are no subscribers
+	cancel1()
+	cancel2()
+	time.Sleep(testTimeout * 2)
+	n = atomic.LoadInt32(&calls)
+	time.Sleep(testTimeout * 3)
+	require.Equal(t, n, atomic.LoadInt32(&calls))
+}
+
+func TestServiceWatchGC(t *testing.T) {
+	l := NewLobby()
+	l.SetTimeout(testTimeout)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var chans []<-chan GameEvent
+	for i := 0; i < 3; i++ {
+		ch, err := l.WatchGames(ctx)
+		require.NoError(t, err)
+		chans = append(chans, ch)
+	}
+	// a single GC loop is running while there are watchers
+	l.mu.RLock()
+	require.Equal(t, 3, l.watchers)
+	require.NotNil(t, l.stopGC)
+	l.mu.RUnlock()
+
+	// and it still removes expired games
+	registerServer(t, l, initServers[0])
+	for _, ch := range chans {
+		expectEvent(t, ch, EventAdd, initServers[0])
+		expectEvent(t, ch, EventRemove, initServers[0])
+	}
+
+	cancel()
+	require.Eventually(t, func() bool {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		return l.watchers == 0 && l.stopGC == nil
+	}, time.Second, testTimeout/10)
+}
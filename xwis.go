@@ -49,6 +49,62 @@ func xwisAccess(v xwis.Access) GameAccess {
 	return ""
 }
 
+func xwisMapType(v GameMode) xwis.MapType {
+	switch v {
+	case ModeKOTR:
+		return xwis.MapTypeKOTR
+	case ModeCTF:
+		return xwis.MapTypeCTF
+	case ModeFlagBall:
+		return xwis.MapTypeFlagBall
+	case ModeChat:
+		return xwis.MapTypeChat
+	case ModeElimination:
+		return xwis.MapTypeElimination
+	case ModeCoop:
+		return xwis.MapTypeCoop
+	case ModeQuest:
+		return xwis.MapTypeQuest
+	}
+	return xwis.MapTypeArena
+}
+
+func xwisAccessFrom(v GameAccess) xwis.Access {
+	switch v {
+	case AccessClosed:
+		return xwis.AccessClosed
+	case AccessPassword:
+		return xwis.AccessPrivate
+	}
+	return xwis.AccessOpen
+}
+
+// GameToXWIS converts Game to xwis.GameInfo. It is the reverse of GameFromXWIS.
+func GameToXWIS(g *Game) *xwis.GameInfo {
+	res := xwis.Res640x480
+	switch {
+	case g.Res.Width >= 1024:
+		res = xwis.Res1024x768
+	case g.Res.Width >= 800:
+		res = xwis.Res800x600
+	}
+	out := &xwis.GameInfo{
+		// XWIS only supports IPv4
+		Addr:       g.AddrFor(AddrIPv4),
+		Name:       g.Name,
+		Map:        g.Map,
+		MapType:    xwisMapType(g.Mode),
+		Access:     xwisAccessFrom(g.Access),
+		Resolution: res,
+		Players:    g.Players.Cur,
+		MaxPlayers: g.Players.Max,
+	}
+	if g.Quest != nil {
+		out.FragLimit = g.Quest.Stage
+	}
+	return out
+}
+
 // GameFromXWIS convert xwis.GameInfo to Game type defined by lobby.
 func GameFromXWIS(g *xwis.GameInfo) *Game {
 	var q *QuestInfo
@@ -64,10 +120,14 @@ func GameFromXWIS(g *xwis.GameInfo) *Game {
 	case xwis.Res1024x768:
 		res.Width, res.Height = 1024, 768
 	}
+	addr := g.Addr
+	if s, err := CanonicalAddr(addr); err == nil {
+		addr = s
+	}
 	return &Game{
 		Name:    g.Name,
-		Address: g.Addr,
-		Port:    DefaultGamePort, // TODO
+		Address: addr,
+		Port:    DefaultGamePort, // XWIS does not report ports, see Aggregate
 		Map:     strings.ToLower(g.Map),
 		Mode:    xwisGameMode(g.MapType),
 		Access:  xwisAccess(g.Access),
@@ -80,9 +140,15 @@ func GameFromXWIS(g *xwis.GameInfo) *Game {
 	}
 }
 
+// NewXWISWithPublisher is similar to NewXWISWithClient, but skips games published to XWIS by the XWISPublisher.
+func NewXWISWithPublisher(c *xwis.Client, p *XWISPublisher) Lister {
+	return &xwisLister{c: c, pub: p}
+}
+
 type xwisLister struct {
 	mu   sync.Mutex
 	c    *xwis.Client
+	pub  *XWISPublisher
 	prev map[string][]string
 }
 
@@ -118,8 +184,12 @@ func (l *xwisLister) ListGames(ctx context.Context) ([]GameInfo, error) {
 		if g == nil {
 			continue
 		}
+		if l.pub != nil && l.pub.Published(r.ID) {
+			// listed by this lobby already
+			continue
+		}
 		v := GameFromXWIS(g)
-		out = append(out, GameInfo{Game: *v, SeenAt: now})
+		out = append(out, GameInfo{Game: *v, SeenAt: now, Source: SourceXWIS})
 	}
 	l.metricsForRooms(out)
 	log.Printf("xwis: %d rooms, %d games", len(list), len(out))